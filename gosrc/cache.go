@@ -0,0 +1,227 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entries do not outlive the process, as compile results are only kept in memory.
+func init() {
+	if GetConfig().Core.TemporaryFolder != "" {
+		os.RemoveAll(compilerCache.folder())
+		os.RemoveAll(referenceCache.folder())
+	}
+}
+
+// Returns the version of a toolchain. An empty string means the toolchain cannot be identified.
+type CompilerVersion func() string
+
+var compilerVersionMap = map[uint8]CompilerVersion {
+	LanguageC:		gccVersion,
+	LanguageCpp:	gccVersion,
+}
+
+// Extend compiler version registry, enabling compile cache for given language.
+// Languages without a version, either from this registry or Language.Version, are never cached.
+func ExtendCompilerVersion(lang uint8, version CompilerVersion) {
+	compilerVersionMap[lang] = version
+}
+
+type gccVersionEntry struct {
+	path		string
+	modTime		time.Time
+	size		int64
+	version		string
+}
+
+var gccVersionCache = gccVersionEntry{}
+var gccVersionMutex = sync.Mutex{}
+
+// Version of Core.GccPath, probed again whenever the binary is replaced.
+func gccVersion() string {
+	path := GetConfig().Core.GccPath
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	gccVersionMutex.Lock()
+	defer gccVersionMutex.Unlock()
+	cached := gccVersionCache
+	if cached.path == path && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.version
+	}
+	output, err := exec.Command(path, "-dumpfullversion", "-dumpversion").Output()
+	if err != nil {
+		return ""
+	}
+	gccVersionCache = gccVersionEntry{ path, info.ModTime(), info.Size(), strings.Join(strings.Fields(string(output)), " ") }
+	return gccVersionCache.version
+}
+
+type compileCacheEntry struct {
+	key			string
+	size		int64
+	// SHA-256 of the executable when stored.
+	digest		string
+	result		CompileResult
+}
+
+// Content-addressed cache of compiled executables, evicting the least recently used entries
+// once their total size exceeds the limit.
+type compileCache struct {
+	// Subdirectory of the temporary folder holding the executables.
+	name		string
+	// Total size of the executables, in bytes.
+	limit		func() int
+	mutex		sync.Mutex
+	entries		map[string]*list.Element
+	// Front is the most recently used.
+	order		*list.List
+	size		int64
+}
+
+// Size of the cache of reference executables, which are kept regardless of Core.CompileCacheSize.
+const referenceCacheSize = 64 << 20
+
+var compilerCache = newCompileCache("cache", func() int { return GetConfig().Core.CompileCacheSize })
+
+// Executables of reference solutions, which are compiled again for every submission otherwise.
+var referenceCache = newCompileCache("reference", func() int { return referenceCacheSize })
+
+func newCompileCache(name string, limit func() int) *compileCache {
+	return &compileCache{ name: name, limit: limit, entries: make(map[string]*list.Element), order: list.New() }
+}
+
+func (c *compileCache) folder() string {
+	return filepath.Join(GetConfig().Core.TemporaryFolder, c.name)
+}
+
+// Cache key of a compilation, or an empty string if it cannot be cached.
+func compileCacheKey(code string, lang uint8, flags []string) string {
+	if GetConfig().Core.CompileCacheSize <= 0 {
+		return ""
+	}
+	language, _ := LookupLanguage(lang)
+	version := language.version()
+	if version == "" {
+		return ""
+	}
+	return compileKey(code, lang, version, flags)
+}
+
+// Cache key of a reference solution. Toolchains without a version are assumed to stay the same while running.
+func referenceCacheKey(solution *Solution) string {
+	language, _ := LookupLanguage(solution.Language)
+	return compileKey(solution.Code, solution.Language, language.version(), compileFlags(solution.Language, solution.Flags))
+}
+
+func compileKey(code string, lang uint8, version string, flags []string) string {
+	language, _ := LookupLanguage(lang)
+	hash := sha256.New()
+	hash.Write([]byte{ lang })
+	hash.Write([]byte(version))
+	for _, arg := range language.Compile {
+		hash.Write([]byte{ 0 })
+		hash.Write([]byte(arg))
+	}
+	hash.Write([]byte{ 0, 0 })
+	for _, flag := range flags {
+		hash.Write([]byte{ 0 })
+		hash.Write([]byte(flag))
+	}
+	hash.Write([]byte{ 0, 0 })
+	hash.Write([]byte(code))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (c *compileCache) path(key string) string {
+	return filepath.Join(c.folder(), key)
+}
+
+// SHA-256 of a file, or an empty string if it cannot be read.
+func fileDigest(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Returns a fresh hard link to the cached executable, which the caller may remove as usual.
+// Entries whose executable changed since stored are dropped, as programs without isolation may write to the cache folder.
+func (c *compileCache) load(key string) (string, CompileResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", CompileResult{}, false
+	}
+	entry := element.Value.(*compileCacheEntry)
+	exe_path := RandomFile(GetConfig().Core.TemporaryFolder)
+	if err := os.Link(c.path(key), exe_path); err != nil {
+		c.remove(element)
+		return "", CompileResult{}, false
+	}
+	// checked through the new link, as the path in the cache folder may be replaced meanwhile
+	if fileDigest(exe_path) != entry.digest {
+		os.Remove(exe_path)
+		c.remove(element)
+		return "", CompileResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return exe_path, entry.result, true
+}
+
+// Stores the executable at exe_path, which remains owned by the caller.
+func (c *compileCache) store(key string, exe_path string, result CompileResult) {
+	info, err := os.Stat(exe_path)
+	// directories cannot be hard linked
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	limit := int64(c.limit())
+	if info.Size() > limit {
+		return
+	}
+	digest := fileDigest(exe_path)
+	if digest == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; ok {
+		// compiled concurrently by another request
+		return
+	}
+	os.MkdirAll(c.folder(), 0o777)
+	if err := os.Link(exe_path, c.path(key)); err != nil {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&compileCacheEntry{ key, info.Size(), digest, result })
+	c.size += info.Size()
+	for c.size > limit {
+		c.remove(c.order.Back())
+	}
+}
+
+// Links already handed out stay valid after removal.
+func (c *compileCache) remove(element *list.Element) {
+	entry := element.Value.(*compileCacheEntry)
+	os.Remove(c.path(entry.key))
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A cgroup v2 leaf holding a single sandboxed run.
+type cgroup struct {
+	path string
+}
+
+// Usage read from a cgroup after the run.
+type cgroupStats struct {
+	// True if the kernel OOM killer fired inside the cgroup.
+	OOMKilled	bool
+	// Peak memory usage in bytes. Zero if memory.peak is unavailable.
+	Peak		int
+	// User and system time of all processes in seconds.
+	Usage		float32
+}
+
+var cgroupOnce = sync.Once{}
+var cgroupReady = false
+
+// Enables the controllers needed by sandboxed runs on Core.CgroupRoot.
+func setupCgroupRoot() {
+	root := GetConfig().Core.CgroupRoot
+	if root == "" {
+		return
+	}
+	err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), ([]byte)("+cpu +memory +pids"), 0o644)
+	cgroupReady = err == nil
+}
+
+// Creates a cgroup leaf with limits of given data point, and at most max_processes processes unless it is zero.
+// Returns nil if cgroup delegation is not available, in which case rlimits should be used instead.
+func newCgroup(point DataPoint, max_processes int) *cgroup {
+	cgroupOnce.Do(setupCgroupRoot)
+	if !cgroupReady {
+		return nil
+	}
+	path := removeNullChars(filepath.Join(GetConfig().Core.CgroupRoot, "run-" + RandomName(8)))
+	if os.Mkdir(path, 0o755) != nil {
+		return nil
+	}
+	c := &cgroup{ path }
+	limits := map[string]string {
+		// one cpu at most, so threads cannot multiply the time limit
+		"cpu.max": "100000 100000",
+	}
+	if point.MemoryLimit > 0 {
+		limits["memory.max"] = strconv.Itoa(point.MemoryLimit)
+		limits["memory.swap.max"] = "0"
+	}
+	if max_processes > 0 {
+		limits["pids.max"] = strconv.Itoa(max_processes)
+	}
+	for file, value := range limits {
+		if file == "memory.swap.max" {
+			c.write(file, value) // absent without swap accounting
+			continue
+		}
+		if c.write(file, value) != nil {
+			c.Remove()
+			return nil
+		}
+	}
+	return c
+}
+
+func (c *cgroup) write(file, value string) error {
+	return os.WriteFile(filepath.Join(c.path, file), ([]byte)(value), 0o644)
+}
+
+func (c *cgroup) readKeyed(file string) map[string]int64 {
+	values := make(map[string]int64)
+	fp, err := os.Open(filepath.Join(c.path, file))
+	if err != nil {
+		return values
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok { continue }
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values
+}
+
+// Path of the cgroup.procs file, written by the child to join this cgroup.
+func (c *cgroup) ProcsPath() string {
+	return filepath.Join(c.path, "cgroup.procs")
+}
+
+// Reads resource usage of the finished run.
+func (c *cgroup) Stats() cgroupStats {
+	stats := cgroupStats{}
+	stats.OOMKilled = c.readKeyed("memory.events")["oom_kill"] > 0
+	if raw, err := os.ReadFile(filepath.Join(c.path, "memory.peak")); err == nil {
+		peak, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+		stats.Peak = peak
+	}
+	stats.Usage = float32(c.readKeyed("cpu.stat")["usage_usec"]) / 1000000
+	return stats
+}
+
+// Removes this cgroup. All processes inside must have been reaped.
+func (c *cgroup) Remove() {
+	os.Remove(c.path)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Compares program output with the expected output. The objective carries parameters such as Epsilon.
+type Checker func(got string, expected string, objective *Objective) bool
+
+var checkerMap = map[string]Checker {
+	"token":		tokenChecker,
+	"float":		floatChecker,
+	"nocase":		caseInsensitiveChecker,
+	"unordered":	unorderedChecker,
+	"yesno":		yesNoChecker,
+}
+
+// Extend checker registry with custom checker, selectable through Objective.Checker.
+func ExtendChecker(name string, checker Checker) {
+	checkerMap[name] = checker
+}
+
+// Error tolerated by the float checker if Epsilon is unset.
+const defaultEpsilon = 1e-6
+
+// Whitespace-separated tokens are equal.
+func tokenChecker(got, expected string, _ *Objective) bool {
+	return slices.Equal(strings.Fields(got), strings.Fields(expected))
+}
+
+// Tokens are equal, except that numbers may differ within Epsilon, either absolutely or relative to the expected one.
+func floatChecker(got, expected string, objective *Objective) bool {
+	epsilon := objective.Epsilon
+	if epsilon <= 0 {
+		epsilon = defaultEpsilon
+	}
+	got_tokens, expected_tokens := strings.Fields(got), strings.Fields(expected)
+	if len(got_tokens) != len(expected_tokens) {
+		return false
+	}
+	for i, token := range expected_tokens {
+		if token == got_tokens[i] {
+			continue
+		}
+		expected_value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return false
+		}
+		got_value, err := strconv.ParseFloat(got_tokens[i], 64)
+		if err != nil || math.IsNaN(got_value) || math.IsInf(got_value, 0) {
+			return false
+		}
+		diff := math.Abs(got_value - expected_value)
+		if diff > epsilon && diff > epsilon * math.Abs(expected_value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tokens are equal regardless of case.
+func caseInsensitiveChecker(got, expected string, _ *Objective) bool {
+	return slices.EqualFunc(strings.Fields(got), strings.Fields(expected), strings.EqualFold)
+}
+
+// Non-blank lines are equal in any order, ignoring surrounding spaces.
+func unorderedChecker(got, expected string, _ *Objective) bool {
+	lines := func(text string) []string {
+		result := []string{}
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				result = append(result, line)
+			}
+		}
+		slices.Sort(result)
+		return result
+	}
+	return slices.Equal(lines(got), lines(expected))
+}
+
+// Tokens are equal, with "yes" and "no" in any case.
+func yesNoChecker(got, expected string, _ *Objective) bool {
+	return slices.EqualFunc(strings.Fields(got), strings.Fields(expected), func(a, b string) bool {
+		if strings.EqualFold(b, "yes") || strings.EqualFold(b, "no") {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	})
+}
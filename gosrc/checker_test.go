@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckers(t *testing.T) {
+	cases := []struct {
+		checker		string
+		got			string
+		expected	string
+		epsilon		float64
+		want		bool
+	}{
+		{ "token", "1  2\n3\n", "1 2 3", 0, true },
+		{ "token", "1 2", "1 2 3", 0, false },
+		{ "token", "Yes", "yes", 0, false },
+		{ "float", "0.3333333", "0.33333333", 0, true },
+		{ "float", "0.333", "0.33333333", 0, false },
+		{ "float", "0.333", "0.33333333", 1e-3, true },
+		// relative to the expected value
+		{ "float", "1000000.5", "1000000", 1e-6, true },
+		{ "float", "1000002", "1000000", 1e-6, false },
+		{ "float", "1.5 abc", "1.5000001 abc", 0, true },
+		{ "float", "1.5 abd", "1.5 abc", 0, false },
+		{ "float", "NaN", "1", 0, false },
+		{ "float", "Inf", "1e308", 1, false },
+		{ "float", "1", "1 2", 0, false },
+		{ "nocase", "HELLO world", "hello World", 0, true },
+		{ "nocase", "hello", "hell0", 0, false },
+		{ "unordered", "b\n  a \n\n", "a\nb", 0, true },
+		{ "unordered", "a\na", "a\nb", 0, false },
+		{ "yesno", "YES 3", "yes 3", 0, true },
+		{ "yesno", "no", "yes", 0, false },
+		// only "yes" and "no" are compared regardless of case
+		{ "yesno", "YES Abc", "yes abc", 0, false },
+	}
+	for _, c := range cases {
+		checker, ok := checkerMap[c.checker]
+		if !ok {
+			t.Fatalf("checker %q is not registered", c.checker)
+		}
+		if got := checker(c.got, c.expected, &Objective{ Epsilon: c.epsilon }); got != c.want {
+			t.Errorf("%s(%q, %q) with epsilon %g = %v, expected %v", c.checker, c.got, c.expected, c.epsilon, got, c.want)
+		}
+	}
+}
+
+func TestJudges(t *testing.T) {
+	cases := []struct {
+		got			string
+		expected	string
+		strict		bool
+		lax			bool
+	}{
+		{ "1 2\n", "1 2\n", true, true },
+		{ "1 2", "1 2\n", false, true },
+		{ "  1 2  \n\n\n3\n", "1 2\n3", false, true },
+		// spaces within lines are significant
+		{ "1  2\n", "1 2\n", false, false },
+		{ "1 3\n", "1 2\n", false, false },
+	}
+	for _, c := range cases {
+		if got := strictJudge(c.got, c.expected); got != c.strict {
+			t.Errorf("strictJudge(%q, %q) = %v, expected %v", c.got, c.expected, got, c.strict)
+		}
+		if got := laxJudge(c.got, c.expected); got != c.lax {
+			t.Errorf("laxJudge(%q, %q) = %v, expected %v", c.got, c.expected, got, c.lax)
+		}
+	}
+}
+
+// Outputs which only pass lax comparison are PE in Strict mode, and count as passed with AcceptPE.
+func TestStrictPresentation(t *testing.T) {
+	config := GetConfig()
+	saved := *config
+	defer func() { *config = saved }()
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh is missing")
+	}
+	config.Core.TemporaryFolder = t.TempDir()
+	config.Core.RootFS = ""
+	config.Core.UsePtrace = false
+	config.Core.DisallowedSyscall = nil
+	config.Core.AsyncExecute = false
+
+	// "compiles" a shell script
+	const lang = 250
+	folder := t.TempDir()
+	ExtendCompiler(lang, func(code string, _ []string) (string, CompileResult) {
+		path := RandomFile(folder)
+		os.WriteFile(path, ([]byte)("#!/bin/sh\n" + code), 0o755)
+		return path, CompileResult{ Ok: true }
+	})
+	defer delete(compilerMap, lang)
+
+	points := []DataPoint{}
+	for _, out := range []string{ "a b\n", "a b\n\n", "a c\n" } {
+		points = append(points, DataPoint{ Out: out, CpuTimeLimit: 1000, MemoryLimit: 64 << 20 })
+	}
+	want := []int{ OK, PE, WA }
+	for _, accept := range []bool{ false, true } {
+		o := Objective{ Mode: Strict, Language: lang, PointCount: len(points), Points: points, AcceptPE: accept }
+		results := o.Run("printf 'a b\\n'")
+		if len(results) != len(want) {
+			t.Fatalf("got %d results, expected %d: %+v", len(results), len(want), results)
+		}
+		for i, result := range results {
+			if result.Code != want[i] {
+				t.Errorf("point %d got %d, expected %d", i, result.Code, want[i])
+			}
+		}
+		score := 100.0 / 3
+		if accept {
+			score *= 2
+		}
+		if got := o.Score(results); math.Abs(got - score) > 1e-9 {
+			t.Errorf("score with AcceptPE %v = %g, expected %g", accept, got, score)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(folder, "*")); len(matches) != 0 {
+		t.Errorf("programs left behind: %v", matches)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
 func init() {
@@ -24,28 +26,82 @@ type CompileResult struct {
 	ExitCode int    `json:"exitCode"`
 	// Standard output / error from compiler.
 	Error    string `json:"error"`
+	// True if the compiler exceeded Core.CompileTimeLimit.
+	Timeout  bool   `json:"timeout"`
+	// Flags passed to the compiler.
+	Flags    []string `json:"flags"`
+	// True if the executable was taken from compile cache.
+	Cached   bool   `json:"cached"`
+	// Origins of the lines of compiled code, to locate errors within template regions. Nil if unknown.
+	SourceMap SourceMap `json:"sourceMap,omitempty"`
 }
 
-// Compiler function.
-type Compiler func(code string) (string, CompileResult)
+// Compiler function. Flags are compiler-specific, such as "-O2" for GCC.
+type Compiler func(code string, flags []string) (string, CompileResult)
 
 var compilerMap = map[uint8]Compiler {
-	LanguageC: func(code string) (string, CompileResult) {
-		return CompileGCC(code, false)
+	LanguageC: func(code string, flags []string) (string, CompileResult) {
+		return CompileGCC(code, false, flags)
 	},
-	LanguageCpp: func(code string) (string, CompileResult) {
-		return CompileGCC(code, true)
+	LanguageCpp: func(code string, flags []string) (string, CompileResult) {
+		return CompileGCC(code, true, flags)
 	},
 }
 
 // Compiler given code using language-specific compiler.
-func Compile(code string, lang uint8) (string, CompileResult) {
+// Empty flags are dropped. If none remain, those configured in Core.CompilerFlags for the language are used.
+// Successful compiles are cached by language, flags, compiler version and code, see Core.CompileCacheSize.
+// The returned executable belongs to the caller either way. Languages without a compiler fail to compile.
+func Compile(code string, lang uint8, flags []string) (string, CompileResult) {
+	flags = compileFlags(lang, flags)
+	language, _ := LookupLanguage(lang)
 	for stored_lang, compiler := range compilerMap {
 		if stored_lang == lang {
-			return compiler(code)
+			key := compileCacheKey(code, lang, flags)
+			if key != "" {
+				if path, result, ok := compilerCache.load(key); ok {
+					result.Cached = true
+					return path, result
+				}
+			}
+			path, result := compiler(code, flags)
+			result.Flags = flags
+			if key != "" && result.Ok {
+				compilerCache.store(key, path, result)
+			}
+			return path, result
 		}
 	}
-	panic(ErrMissingCompiler)
+	return "", CompileResult{ Compiler: language.Name, Flags: flags, Error: ErrMissingCompiler.Error() }
+}
+
+// Flags a compile is run with, see Compile.
+func compileFlags(lang uint8, given []string) []string {
+	flags := []string{}
+	for _, flag := range given {
+		if flag != "" {
+			flags = append(flags, flag)
+		}
+	}
+	if len(flags) == 0 {
+		language, _ := LookupLanguage(lang)
+		flags = GetConfig().Core.CompilerFlags[language.Name]
+	}
+	return flags
+}
+
+// Compiles a reference solution, reusing the executable of an earlier compile kept in referenceCache.
+func compileReference(solution *Solution) (string, CompileResult) {
+	key := referenceCacheKey(solution)
+	if path, result, ok := referenceCache.load(key); ok {
+		result.Cached = true
+		return path, result
+	}
+	path, result := Compile(solution.Code, solution.Language, solution.Flags)
+	if result.Ok {
+		referenceCache.store(key, path, result)
+	}
+	return path, result
 }
 
 // Extend compiler registry with custom compiler.
@@ -54,29 +110,137 @@ func ExtendCompiler(lang uint8, compiler Compiler) {
 }
 
 // Use GCC to compile code. Returns output path and result.
-func CompileGCC(code string, cpp bool) (string, CompileResult) {
-	src_path, exe_path := RandomFilePair(GetConfig().Core.TemporaryFolder)
+// Flags starting with "-l" are placed after the source file, as the linker resolves libraries in order.
+func CompileGCC(code string, cpp bool, flags []string) (string, CompileResult) {
+	folder, err := newCompileFolder()
+	if err != nil {
+		return "", CompileResult{ Compiler: "gcc", Error: "compile folder could not be created" }
+	}
+	defer os.RemoveAll(folder)
+	src_path, exe_path := filepath.Join(folder, "src"), filepath.Join(folder, "exe")
 	os.WriteFile(src_path, ([]byte)(code), 0o777)
-	defer os.Remove(src_path)
-	var cmd *exec.Cmd
+	var argv []string
 	if cpp {
-		cmd = exec.Command(GetConfig().Core.GccPath, "-x", "c++", "-o", exe_path, src_path, "-lstdc++")
+		argv = []string{ GetConfig().Core.GccPath, "-x", "c++" }
 	} else {
-		cmd = exec.Command(GetConfig().Core.GccPath, "-x", "c", "-o", exe_path, src_path)
+		argv = []string{ GetConfig().Core.GccPath, "-x", "c" }
 	}
-	output, err := cmd.CombinedOutput()
+	libraries := []string{}
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "-l") {
+			libraries = append(libraries, flag)
+		} else {
+			argv = append(argv, flag)
+		}
+	}
+	argv = append(argv, "-o", exe_path, src_path)
+	argv = append(argv, libraries...)
+	if cpp {
+		argv = append(argv, "-lstdc++")
+	}
+	result := RunCompiler("gcc", argv, folder)
+	if !result.Ok {
+		return "", result
+	}
+	return takeExecutable(exe_path, result)
+}
+
+// Creates a folder of its own for a compile, holding the source and the executable.
+// In isolation mode it is the only part of Core.TemporaryFolder the compiler can see.
+func newCompileFolder() (string, error) {
+	folder, err := filepath.Abs(RandomFile(GetConfig().Core.TemporaryFolder))
 	if err != nil {
-		return "", CompileResult{
-			Ok:       false,
-			Compiler: "gcc",
-			ExitCode: cmd.ProcessState.ExitCode(),
-			Error:    string(output),
+		return "", err
+	}
+	return folder, os.Mkdir(folder, 0o777)
+}
+
+// Moves an executable out of its compile folder, which the caller removes.
+func takeExecutable(exe_path string, result CompileResult) (string, CompileResult) {
+	path := RandomFile(GetConfig().Core.TemporaryFolder)
+	if os.Rename(exe_path, path) != nil {
+		result.Ok = false
+		result.Error += "\nexecutable could not be moved"
+		return "", result
+	}
+	return path, result
+}
+
+// Paths hidden from compilers in isolation mode: the configuration, the temporary folder holding other runs and
+// cached executables, and the cgroups limiting them.
+func compilerHiddenPaths() []string {
+	config := GetConfig()
+	hidden := []string{}
+	for _, path := range []string{ config.Location, config.Core.TemporaryFolder, config.Core.CgroupRoot } {
+		if path == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			hidden = append(hidden, abs)
 		}
 	}
-	return exe_path, CompileResult{
-		Ok:       true,
-		Compiler: "gcc",
-		ExitCode: 0,
-		Error:    "",
+	return hidden
+}
+
+// Limits of compilers, if not configured.
+const (
+	defaultCompileTimeLimit		= 10000
+	defaultCompileMemoryLimit	= 512 << 20
+	defaultCompileOutputLimit	= 64 << 20
+	// gcc alone runs cc1, as, collect2 and ld
+	defaultCompileMaxProcesses	= 64
+)
+
+func orDefault(value int, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Runs a compiler command in sandbox with Core.CompileTimeLimit, Core.CompileMemoryLimit and Core.CompileOutputLimit.
+// The output limit applies to both the diagnostics kept and every file written. No syscall is disallowed.
+// In isolation mode the compiler runs in namespaces of its own, where compilerHiddenPaths are covered by empty ones
+// and only its folder, see newCompileFolder, stays visible as the working directory.
+func RunCompiler(name string, argv []string, folder string) CompileResult {
+	core := GetConfig().Core
+	output_limit := orDefault(core.CompileOutputLimit, defaultCompileOutputLimit)
+	limits := DataPoint{
+		CpuTimeLimit: orDefault(core.CompileTimeLimit, defaultCompileTimeLimit),
+		MemoryLimit:  orDefault(core.CompileMemoryLimit, defaultCompileMemoryLimit),
+		OutputLimit:  output_limit,
+	}
+	opts := execOptions{
+		stderrLimit:	output_limit,
+		fileSizeLimit:	output_limit,
+		maxProcesses:	orDefault(core.CompileMaxProcesses, defaultCompileMaxProcesses),
+	}
+	// sources may embed any file they can open, such as with #include or .incbin
+	if core.RootFS != "" {
+		opts.hidden = compilerHiddenPaths()
+		opts.visible = folder
+	}
+	output, execution_result := execute(Command{ Argv: argv }, limits, FileIO{}, nil, opts)
+	result := CompileResult{
+		Ok:       false,
+		Compiler: name,
+		ExitCode: execution_result.ExitCode,
+		Error:    output + execution_result.Stderr,
+	}
+	switch execution_result.Code {
+	case OK:
+		result.Ok = execution_result.ExitCode == 0
+	case TLE:
+		result.Timeout = true
+		result.Error += "\ncompile time limit exceeded"
+	case MLE:
+		result.Error += "\ncompile memory limit exceeded"
+	case OLE:
+		result.Error += "\ncompile output limit exceeded"
+	case RE:
+		result.Error += fmt.Sprintf("\ncompiler terminated by signal %d", execution_result.TermSig)
+	default:
+		result.Error += "\ncompiler could not be run"
 	}
+	return result
 }
\ No newline at end of file
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Compilers see neither the configuration nor other files in the temporary folder in isolation mode.
+func TestCompilerHiddenPaths(t *testing.T) {
+	config := GetConfig()
+	saved := *config
+	defer func() { *config = saved }()
+	if config.Core.GccPath == "" {
+		config.Core.GccPath = "/usr/bin/gcc"
+	}
+	if _, err := os.Stat(config.Core.GccPath); err != nil {
+		t.Skip("gcc is not installed")
+	}
+	secret := "zdotoj-secret"
+	config.Location = filepath.Join(t.TempDir(), "config.toml")
+	config.Core.TemporaryFolder = t.TempDir()
+	config.Core.RootFS = t.TempDir()
+	config.Core.CompileCacheSize = 0
+	os.WriteFile(config.Location, ([]byte)("pass = \"" + secret + "\"\n"), 0o644)
+	stored := filepath.Join(config.Core.TemporaryFolder, "stored")
+	os.WriteFile(stored, ([]byte)(secret), 0o644)
+
+	path, result := Compile("int main() { return 0; }", LanguageC, nil)
+	if !result.Ok {
+		t.Skip("namespaces are not available: " + result.Error)
+	}
+	os.Remove(path)
+
+	embed := func(file string) string {
+		return `#include <stdio.h>
+__asm__(".section .rodata\n.global blob\nblob:\n.incbin \"` + file + `\"\n.byte 0\n.text");
+extern const char blob[];
+int main() { printf("%s", blob); }`
+	}
+	for _, code := range []string{
+		embed(config.Location),
+		embed(stored),
+		"#include \"" + config.Location + "\"\nint main() { return 0; }",
+		"#include \"" + stored + "\"\nint main() { return 0; }",
+	} {
+		path, result := Compile(code, LanguageC, nil)
+		// diagnostics quote included lines
+		if strings.Contains(result.Error, secret) {
+			t.Errorf("compiler revealed a hidden file:\n%s", code)
+		}
+		if !result.Ok {
+			continue
+		}
+		output, _ := exec.Command(path).Output()
+		os.Remove(path)
+		if strings.Contains(string(output), secret) {
+			t.Errorf("compiled program revealed a hidden file:\n%s", code)
+		}
+	}
+}
+
+// Languages without a compiler fail to compile rather than panic.
+func TestCompileMissingCompiler(t *testing.T) {
+	path, result := Compile("", 255, nil)
+	if path != "" || result.Ok || result.Error == "" {
+		t.Errorf("compiling without a compiler got %q and %+v, expected a failed compile", path, result)
+	}
+}
+
+// Reference executables are reused even if the compile cache is off.
+func TestCompileReference(t *testing.T) {
+	config := GetConfig()
+	saved := *config
+	defer func() { *config = saved }()
+	config.Core.TemporaryFolder = t.TempDir()
+	config.Core.CompileCacheSize = 0
+
+	const lang = 251
+	compiles := 0
+	ExtendCompiler(lang, func(code string, _ []string) (string, CompileResult) {
+		compiles++
+		path := RandomFile(config.Core.TemporaryFolder)
+		os.WriteFile(path, ([]byte)(code), 0o755)
+		return path, CompileResult{ Ok: true }
+	})
+	defer delete(compilerMap, lang)
+
+	for i, code := range []string{ "a", "a", "b" } {
+		path, result := compileReference(&Solution{ Code: code, Language: lang })
+		if !result.Ok {
+			t.Fatalf("compile %d failed", i)
+		}
+		content, _ := os.ReadFile(path)
+		os.Remove(path)
+		if string(content) != code {
+			t.Errorf("compile %d got %q, expected %q", i, content, code)
+		}
+		if result.Cached != (i == 1) {
+			t.Errorf("compile %d: cached = %v", i, result.Cached)
+		}
+	}
+	if compiles != 2 {
+		t.Errorf("compiled %d times, expected 2", compiles)
+	}
+}
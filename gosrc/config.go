@@ -12,8 +12,25 @@ import (
 type CoreConfig struct {
 	TemporaryFolder		string
 	GccPath 			string
+	CompilerFlags		map[string][]string
 	DisallowedSyscall	[]int32
 	AsyncExecute		bool
+	MaxParallel			int
+	UsePtrace			bool
+	CgroupRoot			string
+	MaxProcesses		int
+	RootFS				string
+	TmpfsSize			int
+	OutputLimit			int
+	StderrLimit			int
+	CompileTimeLimit	int
+	CompileMemoryLimit	int
+	CompileOutputLimit	int
+	CompileMaxProcesses	int
+	CompileCacheSize	int
+	TestlibPath			string
+	CheckerTimeLimit	int
+	CheckerMemoryLimit	int
 }
 
 // Database configuration section.
@@ -49,6 +66,7 @@ type Config struct {
 	Database	DatabaseConfig
 	Http		HttpConfig
 	Web			WebConfig
+	Languages	[]Language
 }
 
 var configCache *Config = nil
@@ -154,10 +154,20 @@ func (d *Database) FindPartialUnitById(id any) *partialUnit {
 			"objectives.points": 0,
 			"objectives.rscript": 0,
 			"objectives.sscript": 0,
+			"objectives.iscript": 0,
+			"objectives.interactor": 0,
+			"objectives.nativechecker": 0,
+			"objectives.validator": 0,
+			"objectives.reference": 0,
+			"objectives.harness.driver": 0,
+			"objectives.languages.harness.driver": 0,
 		}),
 	).Decode(unit) != nil {
 		return nil
 	}
+	for i := range unit.Objectives {
+		unit.Objectives[i].applyHarness()
+	}
 	return unit
 }
 
@@ -253,14 +263,23 @@ func (d *Database) UpdateRecord(userId any, unitId any, index int, entry RecordE
 		_, err := col.UpdateOne(context.Background(), bson.M{
 			"user": convertObjectID(userId),
 			"unit": convertObjectID(unitId),
-			fmt.Sprintf("entries.%d.passed", index): bson.M{
-				"$lte": entry.Passed, // update if passed >= current value
+			// update if score >= current value, or passed >= current value for entries recorded before scores
+			"$or": bson.A {
+				bson.M{
+					fmt.Sprintf("entries.%d.score", index): bson.M{ "$lte": entry.Score },
+				},
+				bson.M{
+					fmt.Sprintf("entries.%d.score", index): bson.M{ "$exists": false },
+					fmt.Sprintf("entries.%d.passed", index): bson.M{ "$lte": entry.Passed },
+				},
 			},
 		}, bson.M {
 			"$set": bson.M{
 				fmt.Sprintf("entries.%d.code", index): entry.Code,
+				fmt.Sprintf("entries.%d.language", index): entry.Language,
 				fmt.Sprintf("entries.%d.passed", index): entry.Passed,
 				fmt.Sprintf("entries.%d.total", index): entry.Total,
+				fmt.Sprintf("entries.%d.score", index): entry.Score,
 			},
 		})
 		if err != nil {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Talks to a running program in interactive mode and decides the result.
+type Interactor interface {
+	// Talks to the program until either side finishes. Writes to stdin reach the program's standard input,
+	// and reads from stdout return its standard output, with EOF once the program exits.
+	Interact(stdin io.Writer, stdout io.Reader)
+	// Returns whether the interaction finished properly, and whether the program passed.
+	Verdict() (bool, bool)
+}
+
+// Interactor backed by a Lua script.
+type scriptInteractor struct {
+	script	string
+	point	DataPoint
+	index	int
+	aux		*AuxData
+	ok		bool
+	pass	bool
+}
+
+func (s *scriptInteractor) Interact(stdin io.Writer, stdout io.Reader) {
+	s.ok, s.pass = InvokeInteractorScript(s.script, s.point, s.index, s.aux, stdin, stdout)
+}
+
+func (s *scriptInteractor) Verdict() (bool, bool) {
+	return s.ok, s.pass
+}
+
+// Interactor backed by a native program, invoked as "interactor <input> <answer>" with both files holding
+// DataPoint.In and DataPoint.Out. It passes the program by exiting with 0. It is trusted and not sandboxed.
+type nativeInteractor struct {
+	path	string
+	point	DataPoint
+	ok		bool
+	pass	bool
+}
+
+func (n *nativeInteractor) Interact(stdin io.Writer, stdout io.Reader) {
+	input_path, answer_path := RandomFile(GetConfig().Core.TemporaryFolder), RandomFile(GetConfig().Core.TemporaryFolder)
+	defer os.Remove(input_path)
+	defer os.Remove(answer_path)
+	if os.WriteFile(input_path, ([]byte)(n.point.In), 0o644) != nil || os.WriteFile(answer_path, ([]byte)(n.point.Out), 0o644) != nil {
+		return
+	}
+	// a pipe of our own, since exec would wait for its copy of stdout to finish even after the interactor exits
+	interactor_stdin, interactor_stdin_parent, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	go func() {
+		io.Copy(interactor_stdin_parent, stdout)
+		interactor_stdin_parent.Close()
+	}()
+	ctx, cancel := interactionContext(n.point)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.path, input_path, answer_path)
+	cmd.Stdin = interactor_stdin
+	cmd.Stdout = stdin
+	err = cmd.Run()
+	interactor_stdin.Close()
+	exit_err := new(exec.ExitError)
+	if err == nil {
+		n.ok, n.pass = true, true
+	} else if errors.As(err, &exit_err) && exit_err.Exited() {
+		n.ok, n.pass = true, false
+	}
+}
+
+func (n *nativeInteractor) Verdict() (bool, bool) {
+	return n.ok, n.pass
+}
+
+// Context of an interaction, which ends a while after the program would have been killed for its wall-clock time.
+func interactionContext(point DataPoint) (context.Context, context.CancelFunc) {
+	if point.WallLimit() <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), time.Duration(point.WallLimit()) * time.Millisecond + drainTimeout)
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"os"
+	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const (
 	LanguageC	uint8	= iota
 	LanguageCpp
+	LanguagePython
+	LanguageJava
+	LanguageGo
+	LanguageRust
+	LanguageJavaScript
 )
 
 var judgeModeFlags = newUint16Flags()
@@ -22,6 +29,10 @@ var (
 	Special	= judgeModeFlags.next()
 	// (0b100). Use RandomJudge script instead of predefined data points.
 	Random	= judgeModeFlags.next()
+	// (0b1000). Treat a nonzero exit status as runtime error (RE).
+	ExitCheck	= judgeModeFlags.next()
+	// (0b10000). Talk to the program through an interactor, which decides the result instead of built-in judgers.
+	Interactive	= judgeModeFlags.next()
 )
 
 // A data point in built-in judge mode.
@@ -30,10 +41,40 @@ type DataPoint struct {
 	In 			string	`json:"in"`
 	// String to expect from program's standard output.
 	Out 		string	`json:"out"`
-	// Time limit, in seconds.
+	// Time limit, in seconds. Superseded by CpuTimeLimit if set.
 	TimeLimit 	int		`json:"timeLimit"`
+	// CPU time limit, in milliseconds.
+	CpuTimeLimit	int	`json:"cpuTimeLimit"`
+	// Wall-clock time limit, in milliseconds. Twice the CPU time limit if unset.
+	WallTimeLimit	int	`json:"wallTimeLimit"`
 	// Memory limit, in bytes.
 	MemoryLimit int		`json:"memoryLimit"`
+	// Output limit, in bytes. Core.OutputLimit if unset.
+	OutputLimit	int		`json:"outputLimit"`
+}
+
+// CPU time limit in milliseconds. Zero if unlimited.
+func (p DataPoint) CpuLimit() int {
+	if p.CpuTimeLimit > 0 {
+		return p.CpuTimeLimit
+	}
+	return p.TimeLimit * 1000
+}
+
+// Wall-clock time limit in milliseconds. Zero if unlimited.
+func (p DataPoint) WallLimit() int {
+	if p.WallTimeLimit > 0 {
+		return p.WallTimeLimit
+	}
+	return p.CpuLimit() * 2
+}
+
+// Output limit in bytes. Zero if unlimited.
+func (p DataPoint) OutLimit() int {
+	if p.OutputLimit > 0 {
+		return p.OutputLimit
+	}
+	return GetConfig().Core.OutputLimit
 }
 
 type Region struct {
@@ -45,6 +86,62 @@ type Region struct {
 	Indent		int			`json:"indent"`
 }
 
+// Function-signature harness, in which users only write the body of a function called by a hidden driver.
+type Harness struct {
+	// Code preceding the function, such as includes.
+	Header		string		`json:"header"`
+	// Function signature, including the opening brace if any, such as "int add(int a, int b) {".
+	Signature	string		`json:"signature"`
+	// Code closing the function, such as "}". Empty for languages delimited by indentation.
+	Footer		string		`json:"footer"`
+	// Code calling the function, such as main. Never sent to users.
+	Driver		string		`json:"driver"`
+}
+
+// Template equivalent to the harness, whose only editable region is the function body.
+// The driver region is left out if the driver is hidden.
+func (h *Harness) Template() []Region {
+	head := h.Signature
+	if h.Header != "" {
+		head = h.Header + "\n" + head
+	}
+	template := []Region {
+		{ Content: head },
+		{ Editable: true, Indent: 1 },
+	}
+	if h.Footer != "" {
+		template = append(template, Region{ Content: h.Footer })
+	}
+	if h.Driver != "" {
+		template = append(template, Region{ Content: h.Driver })
+	}
+	return template
+}
+
+// A reference solution, whose output on the input of every data point is the expected output.
+type Solution struct {
+	// Source code.
+	Code		string		`json:"code"`
+	// Programming language, any registered one.
+	Language	uint8		`json:"language"`
+	// Compiler flags. Core.CompilerFlags of the language if empty.
+	Flags		[]string	`json:"flags"`
+}
+
+// A language accepted by an objective besides its own.
+type ObjectiveLanguage struct {
+	// Programming language.
+	Language		uint8		`json:"language"`
+	// Template to restrict user input area. Input is unrestricted if nil.
+	Template		[]Region	`json:"template"`
+	// Function-signature harness, superseding Template if not nil.
+	Harness			*Harness	`json:"harness"`
+	// Compiler flags. Core.CompilerFlags of the language if empty.
+	Flags			[]string	`json:"flags"`
+	// Multiplier of time limits, on top of that of the language. 1 if unset.
+	TimeMultiplier	float64		`json:"timeMultiplier"`
+}
+
 // An objective without points / scripts.
 type ObjectiveInfo struct {
 	// Name of objective.
@@ -55,12 +152,34 @@ type ObjectiveInfo struct {
 	Difficulty	int			`json:"difficulty"`
 	// Template to restrict user input area.
 	Template	[]Region	`json:"template"`
+	// Function-signature harness, superseding Template if not nil.
+	Harness		*Harness	`json:"harness"`
 	// Judging mode.
 	Mode		uint16		`json:"mode"`
+	// Built-in checker replacing lax comparison, such as "float". Ignored in Strict, Special and Interactive mode.
+	Checker		string		`json:"checker"`
+	// Error tolerated by the "float" checker, both absolute and relative. 1e-6 if unset.
+	Epsilon		float64		`json:"epsilon"`
+	// Whether presentation errors (PE) count as passed.
+	AcceptPE	bool		`json:"acceptPE"`
 	// Programming language.
 	Language	uint8		`json:"language"`
+	// Compiler flags, such as "-std=c++17" or "-lm". Core.CompilerFlags of the language if empty.
+	Flags		[]string	`json:"flags"`
+	// Multiplier of time limits in Language, on top of that of the language. 1 if unset.
+	TimeMultiplier	float64	`json:"timeMultiplier"`
+	// Languages accepted besides Language.
+	Languages	[]ObjectiveLanguage	`json:"languages"`
 	// Amount of points. In RandomJudge mode, this number indicates how many points the script should generate.
 	PointCount	int			`json:"pointCount"`
+	// Subtasks grouping data points. Nil if every point weighs the same.
+	Subtasks	[]Subtask	`json:"subtasks"`
+	// Whether standard error is shown in RE and WA results.
+	ShowStderr	bool		`json:"showStderr"`
+	// Name of the file to read input from. Empty string for standard input.
+	InputFile	string		`json:"inputFile"`
+	// Name of the file to write output to. Empty string for standard output.
+	OutputFile	string		`json:"outputFile"`
 }
 
 // An objective is a single problem to be solved.
@@ -73,18 +192,50 @@ type Objective struct {
 	Difficulty	int			`json:"difficulty"`
 	// Template to restrict user input area.
 	Template	[]Region	`json:"template"`
+	// Function-signature harness, superseding Template if not nil.
+	Harness		*Harness	`json:"harness"`
 	// Judging mode.
 	Mode		uint16		`json:"mode"`
+	// Built-in checker replacing lax comparison, such as "float". Ignored in Strict, Special and Interactive mode.
+	Checker		string		`json:"checker"`
+	// Error tolerated by the "float" checker, both absolute and relative. 1e-6 if unset.
+	Epsilon		float64		`json:"epsilon"`
+	// Whether presentation errors (PE) count as passed.
+	AcceptPE	bool		`json:"acceptPE"`
 	// Programming language.
 	Language	uint8		`json:"language"`
+	// Compiler flags, such as "-std=c++17" or "-lm". Core.CompilerFlags of the language if empty.
+	Flags		[]string	`json:"flags"`
+	// Multiplier of time limits in Language, on top of that of the language. 1 if unset.
+	TimeMultiplier	float64	`json:"timeMultiplier"`
+	// Languages accepted besides Language.
+	Languages	[]ObjectiveLanguage	`json:"languages"`
 	// Amount of points. In RandomJudge mode, this number indicates how many points the script should generate.
 	PointCount	int			`json:"pointCount"`
+	// Subtasks grouping data points. Nil if every point weighs the same.
+	Subtasks	[]Subtask	`json:"subtasks"`
+	// Whether standard error is shown in RE and WA results.
+	ShowStderr	bool		`json:"showStderr"`
+	// Name of the file to read input from. Empty string for standard input.
+	InputFile	string		`json:"inputFile"`
+	// Name of the file to write output to. Empty string for standard output.
+	OutputFile	string		`json:"outputFile"`
 	// Data points. Nil in RandomJudge mode.
 	Points		[]DataPoint	`json:"points"`
 	// RandomJudge script. Empty string if disabled.
 	RScript		string		`json:"rScript"`
 	// SpecialJudge script. Empty string if disabled.
 	SScript		string		`json:"sScript"`
+	// Interactor script. Empty string if disabled.
+	IScript		string		`json:"iScript"`
+	// Source of a native interactor in C++, used instead of IScript if not empty.
+	Interactor	string		`json:"interactor"`
+	// Source of a testlib checker in C++, used instead of SScript in Special mode if not empty.
+	NativeChecker	string	`json:"nativeChecker"`
+	// Source of a testlib validator in C++, checking the input of every data point if not empty.
+	Validator	string		`json:"validator"`
+	// Reference solution, whose standard output replaces DataPoint.Out if not nil.
+	Reference	*Solution	`json:"reference"`
 }
 
 // Unit without the set of objectives.
@@ -136,6 +287,17 @@ type WAResult struct {
 	Got			string	`json:"got"`
 	// Expected standard output.
 	Expected	string	`json:"expected"`
+	// Message of the native checker, if any.
+	Message		string	`json:"message,omitempty"`
+}
+
+// Result.Data of partially correct (PC).
+type PCResult struct {
+	ExecResult
+	// Share of the score of the data point earned, within (0, 1).
+	Score		float64	`json:"score"`
+	// Message of the native checker.
+	Message		string	`json:"message"`
 }
 
 // Result of an objective run.
@@ -149,6 +311,10 @@ type Result struct {
 const (
 	// compile / judge:
 
+	PC	int = -7 // Partially correct, as decided by a native checker.
+	PE	int = -6 // Presentation error, such as output matching in lax mode only.
+	SK	int = -5 // Skipped, as its subtasks have failed.
+	CTLE int = -4 // Compile time limit exceeded.
 	IE	int = -3 // Internal error.
 	CE	int = -2 // Compile error.
 	WA 	int = -1 // Wrong answer.
@@ -160,29 +326,147 @@ const (
 	TLE int = 2 // Time limit exceeded.
 	MLE	int = 3 // Memory limit exceeded.
 	SE	int = 4 // Security error.
+	OLE int = 5 // Output limit exceeded.
 )
 
+// This objective as run in given language, which is either Language or one of Languages.
+// The template of a harness replaces Template.
+func (o Objective) In(lang uint8) (Objective, bool) {
+	found := lang == o.Language
+	for _, option := range o.Languages {
+		if !found && option.Language == lang {
+			o.Language = option.Language
+			o.Template = option.Template
+			o.Harness = option.Harness
+			o.Flags = option.Flags
+			o.TimeMultiplier = option.TimeMultiplier
+			found = true
+		}
+	}
+	if found && o.Harness != nil {
+		o.Template = o.Harness.Template()
+	}
+	return o, found
+}
+
+// Replaces templates with those of harnesses, whose drivers are expected to be hidden already.
+func (o *ObjectiveInfo) applyHarness() {
+	if o.Harness != nil {
+		o.Template = o.Harness.Template()
+	}
+	for i := range o.Languages {
+		if harness := o.Languages[i].Harness; harness != nil {
+			o.Languages[i].Template = harness.Template()
+		}
+	}
+}
+
 // Run this objective against given code.
 func (o *Objective) Run(code string) []Result {
-	path, compile_result := Compile(code, o.Language)
+	path, compile_result := Compile(code, o.Language, o.Flags)
 	if !compile_result.Ok {
+		compile_code := CE
+		if compile_result.Timeout {
+			compile_code = CTLE
+		}
 		return []Result {
 			{
-				Code: CE,
+				Code: compile_code,
 				Data: compile_result,
 			},
 		}
 	}
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
+
+	interactor_path := ""
+	if judgeModeFlags.check(o.Mode, Interactive) && o.Interactor != "" {
+		var interactor_result CompileResult
+		interactor_path, interactor_result = Compile(o.Interactor, LanguageCpp, nil)
+		if !interactor_result.Ok {
+			return []Result {
+				{
+					Code: IE,
+					Data: "Interactor",
+				},
+			}
+		}
+		defer os.Remove(interactor_path)
+	}
+
+	checker_path := ""
+	if judgeModeFlags.check(o.Mode, Special) && o.NativeChecker != "" {
+		var checker_result CompileResult
+		checker_path, checker_result = compileTestlib(o.NativeChecker)
+		if !checker_result.Ok {
+			return []Result {
+				{
+					Code: IE,
+					Data: "Checker",
+				},
+			}
+		}
+		defer os.Remove(checker_path)
+	}
+
+	validator_path := ""
+	if o.Validator != "" {
+		var validator_result CompileResult
+		validator_path, validator_result = compileTestlib(o.Validator)
+		if !validator_result.Ok {
+			return []Result {
+				{
+					Code: IE,
+					Data: "Validator",
+				},
+			}
+		}
+		defer os.Remove(validator_path)
+	}
+
+	reference_path := ""
+	var reference_language Language
+	if o.Reference != nil {
+		// languages without a compiler, such as those whose toolchain is missing on this host
+		if _, ok := compilerMap[o.Reference.Language]; !ok {
+			return []Result {
+				{
+					Code: IE,
+					Data: "Reference",
+				},
+			}
+		}
+		var reference_result CompileResult
+		reference_path, reference_result = compileReference(o.Reference)
+		if !reference_result.Ok {
+			return []Result {
+				{
+					Code: IE,
+					Data: "Reference",
+				},
+			}
+		}
+		defer os.RemoveAll(reference_path)
+		reference_language, _ = LookupLanguage(o.Reference.Language)
+	}
 
 	results := make([]Result, o.PointCount)
+	async := GetConfig().Core.AsyncExecute
+	aux := NewAuxData(async)
+	skipper := newSubtaskSkipper(o.Subtasks)
+	language, _ := LookupLanguage(o.Language)
+	if o.TimeMultiplier > 0 {
+		if language.TimeMultiplier <= 0 {
+			language.TimeMultiplier = 1
+		}
+		language.TimeMultiplier *= o.TimeMultiplier
+	}
 
 	runOne := func(i int) {
 		var point DataPoint
 		if !judgeModeFlags.check(o.Mode, Random) {
 			point = o.Points[i]
 		} else {
-			ok, point_temp := InvokeRandomJudgeScript(o.RScript, i)
+			ok, point_temp := InvokeRandomJudgeScript(o.RScript, i, aux)
 			if !ok {
 				results[i] = Result {
 					Code: IE,
@@ -192,28 +476,133 @@ func (o *Objective) Run(code string) []Result {
 			}
 			point = point_temp
 		}
-		output, execution_result := Execute(path, point)
+		if validator_path != "" && !validateTestlib(validator_path, point) {
+			results[i] = Result {
+				Code: IE,
+				Data: "Validator",
+			}
+			return
+		}
+		if reference_path != "" {
+			// limits are those of the data point, scaled for the language of the reference
+			expected, reference_result := Execute(reference_language.command(reference_path), reference_language.scale(point), FileIO{}, nil)
+			if reference_result.Code != OK || reference_result.ExitCode != 0 {
+				results[i] = Result {
+					Code: IE,
+					Data: "Reference",
+				}
+				return
+			}
+			point.Out = expected
+		}
+		point = language.scale(point)
+		var interactor Interactor
+		if judgeModeFlags.check(o.Mode, Interactive) {
+			if interactor_path != "" {
+				interactor = &nativeInteractor{ path: interactor_path, point: point }
+			} else {
+				interactor = &scriptInteractor{ script: o.IScript, point: point, index: i, aux: aux }
+			}
+		}
+		output, execution_result := Execute(language.command(path), point, FileIO{ o.InputFile, o.OutputFile }, interactor)
+		stderr := execution_result.Stderr
+		execution_result.Stderr = ""
+		if !o.ShowStderr {
+			stderr = ""
+		}
+		if execution_result.Code == OK && execution_result.ExitCode != 0 && judgeModeFlags.check(o.Mode, ExitCheck) {
+			execution_result = ExecResult {
+				Code:     RE,
+				ExitCode: execution_result.ExitCode,
+			}
+		}
+		if interactor != nil && execution_result.Code != IE {
+			ok, pass := interactor.Verdict()
+			if !ok {
+				results[i] = Result {
+					Code: IE,
+					Data: "Interactor",
+				}
+				return
+			}
+			// the program is killed by SIGPIPE once the interactor hangs up, which is no failure of its own
+			hung_up := execution_result.Code == RE && execution_result.TermSig == int(syscall.SIGPIPE)
+			if !pass && (execution_result.Code == OK || hung_up) {
+				execution_result.Stderr = stderr
+				results[i] = Result {
+					Code: WA,
+					Data: execution_result,
+				}
+				return
+			}
+		}
 		if execution_result.Code != OK {
+			if execution_result.Code == RE {
+				execution_result.Stderr = stderr
+			}
 			results[i] = Result {
 				Code: execution_result.Code,
 				Data: execution_result,
 			}
 			return
 		}
-		if judgeModeFlags.check(o.Mode, Strict) {
+		wrongAnswer := func() Result {
+			with_stderr := execution_result
+			with_stderr.Stderr = stderr
+			return Result {
+				Code: WA,
+				Data: WAResult { ExecResult: with_stderr, Got: output, Expected: point.Out },
+			}
+		}
+		if interactor != nil {
+			results[i] = Result {
+				Code: OK,
+				Data: execution_result,
+			}
+		} else if judgeModeFlags.check(o.Mode, Strict) {
 			if strictJudge(output, point.Out) {
 				results[i] = Result {
 					Code: OK,
 					Data: execution_result,
 				}
+			} else if laxJudge(output, point.Out) {
+				result := wrongAnswer()
+				result.Code = PE
+				results[i] = result
 			} else {
+				results[i] = wrongAnswer()
+			}
+		} else if checker_path != "" {
+			verdict, ok := checkTestlib(checker_path, point, output)
+			if !ok {
 				results[i] = Result {
-					Code: WA,
-					Data: WAResult { execution_result, output, point.Out },
+					Code: IE,
+					Data: "Checker",
+				}
+				return
+			}
+			switch verdict.Code {
+			case OK:
+				results[i] = Result {
+					Code: OK,
+					Data: execution_result,
+				}
+			case PC:
+				results[i] = Result {
+					Code: PC,
+					Data: PCResult { execution_result, verdict.Share, verdict.Message },
+				}
+			default:
+				result := wrongAnswer()
+				data := result.Data.(WAResult)
+				data.Message = verdict.Message
+				results[i] = Result {
+					Code: verdict.Code,
+					Data: data,
 				}
 			}
 		} else if judgeModeFlags.check(o.Mode, Special) {
-			ok, pass := InvokeSpecialJudgeScript(o.SScript, output, point.Out, i)
+			ok, pass := InvokeSpecialJudgeScript(o.SScript, output, point.Out, i, aux)
 			if ok {
 				if pass {
 					results[i] = Result {
@@ -221,10 +610,7 @@ func (o *Objective) Run(code string) []Result {
 						Data: execution_result,
 					}
 				} else {
-					results[i] = Result {
-						Code: WA,
-						Data: WAResult { execution_result, output, point.Out },
-					}
+					results[i] = wrongAnswer()
 				}
 			} else {
 				results[i] = Result {
@@ -232,36 +618,64 @@ func (o *Objective) Run(code string) []Result {
 					Data: "SpecialJudge",
 				}
 			}
-		} else {
-			if laxJudge(output, point.Out) {
+		} else if o.Checker != "" {
+			checker, ok := checkerMap[o.Checker]
+			if !ok {
+				results[i] = Result {
+					Code: IE,
+					Data: "Checker",
+				}
+			} else if checker(output, point.Out, o) {
 				results[i] = Result {
 					Code: OK,
 					Data: execution_result,
 				}
 			} else {
+				results[i] = wrongAnswer()
+			}
+		} else {
+			if laxJudge(output, point.Out) {
 				results[i] = Result {
-					Code: WA,
-					Data: WAResult { execution_result, output, point.Out },
+					Code: OK,
+					Data: execution_result,
 				}
+			} else {
+				results[i] = wrongAnswer()
 			}
 		}
 	}
 
-	defer ClearAuxData()
-	
-	if GetConfig().Core.AsyncExecute {
+	judgeOne := func(i int) {
+		if skipper.skips(i) {
+			results[i] = Result {
+				Code: SK,
+			}
+			return
+		}
+		runOne(i)
+		skipper.record(i, o.share(results[i]))
+	}
+
+	if async {
+		parallel := GetConfig().Core.MaxParallel
+		if parallel <= 0 {
+			parallel = runtime.NumCPU()
+		}
+		slots := make(chan struct{}, parallel)
 		wg := sync.WaitGroup{}
 		wg.Add(o.PointCount)
 		for i := 0; i < o.PointCount; i++ {
+			slots <- struct{}{}
 			go func (x int) {
 				defer wg.Done()
-				runOne(x)
-			} (i) // BUG: signal receiver does not run in executing thread
+				defer func() { <-slots }()
+				judgeOne(x)
+			} (i)
 		}
 		wg.Wait()
 	} else {
 		for i := 0; i < o.PointCount; i++ {
-			runOne(i)
+			judgeOne(i)
 		}
 	}
 
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Languages in config take precedence over built-in ones with the same id.
+// Built-in languages with commands are only available if their toolchain is installed.
+func init() {
+	for _, lang := range GetConfig().Languages {
+		RegisterLanguage(lang)
+	}
+	for id, lang := range languageRegistry {
+		if _, ok := compilerMap[id]; !ok && lang.installed() {
+			compilerMap[id] = commandCompiler(lang)
+		}
+	}
+}
+
+// Describes a programming language.
+type Language struct {
+	// Identifier stored in objectives.
+	Id					uint8		`json:"id"`
+	// Short name, such as "cpp". Also the key of Core.CompilerFlags.
+	Name				string		`json:"name"`
+	// Name shown to users, such as "C++".
+	DisplayName			string		`json:"displayName"`
+	// Syntax highlighting mode of the default UI. Name if empty.
+	Highlight			string		`json:"highlight"`
+	// Extension of source files, including the dot.
+	Extension			string		`json:"extension"`
+	// Name of the source file, such as "Main.java", which is then written into a directory of its own.
+	// A random name with Extension if empty.
+	Source				string		`json:"source"`
+	// Version of the toolchain. Probed through the version registry if empty.
+	Version				string		`json:"version"`
+	// Compile command. "{src}" and "{exe}" are replaced by the source and executable paths,
+	// and a "{flags}" element expands to the compiler flags. The first element must be an absolute path.
+	// If the command does not write "{exe}", the source itself is run, so a mere syntax check suffices for interpreted languages.
+	// If empty, the compiler registered through ExtendCompiler is used, or the source is run unchecked if there is none.
+	Compile				[]string	`json:"compile,omitempty"`
+	// Run command, with "{exe}" replaced by the compiled program, which may be a directory.
+	// The first element must be an absolute path, present in Core.RootFS in isolation mode. Empty for native executables.
+	Run					[]string	`json:"run,omitempty"`
+	// System calls exempted from Core.DisallowedSyscall, such as clone3 (435) for runtimes starting threads.
+	AllowedSyscall		[]int32		`json:"allowedSyscall,omitempty"`
+	// Whether the runtime reserves address space far beyond its use, see Command.ReservesMemory.
+	ReservesMemory		bool		`json:"reservesMemory,omitempty"`
+	// Multiplier of time limits of data points. 1 if unset.
+	TimeMultiplier		float64		`json:"timeMultiplier"`
+	// Multiplier of memory limits of data points. 1 if unset.
+	MemoryMultiplier	float64		`json:"memoryMultiplier"`
+	// Code standing for an empty editable region when templates are validated, such as "pass".
+	Placeholder			string		`json:"placeholder"`
+}
+
+var languageRegistry = map[uint8]Language {
+	LanguageC: {
+		Id:				LanguageC,
+		Name:			"c",
+		DisplayName:	"C",
+		Extension:		".c",
+	},
+	LanguageCpp: {
+		Id:				LanguageCpp,
+		Name:			"cpp",
+		DisplayName:	"C++",
+		Extension:		".cpp",
+	},
+	LanguagePython: {
+		Id:				LanguagePython,
+		Name:			"python",
+		DisplayName:	"Python 3",
+		Extension:		".py",
+		// byte-compiled beforehand, so that syntax errors show up as CE
+		Compile:		[]string{ "/usr/bin/python3", "-c", pythonCompileScript, "{src}", "{exe}" },
+		Run:			[]string{ "/usr/bin/python3", "{exe}" },
+		Placeholder:	"pass",
+	},
+	LanguageJava: {
+		Id:					LanguageJava,
+		Name:				"java",
+		DisplayName:		"Java",
+		Extension:			".java",
+		Source:				"Main.java",
+		Compile:			[]string{ "/usr/bin/javac", "-encoding", "UTF-8", "{flags}", "-d", "{exe}", "{src}" },
+		Run:				[]string{ "/usr/bin/java", "-cp", "{exe}", "Main" },
+		AllowedSyscall:		[]int32{ 435 },
+		ReservesMemory:		true,
+		TimeMultiplier:		2,
+		MemoryMultiplier:	2,
+	},
+	LanguageGo: {
+		Id:				LanguageGo,
+		Name:			"go",
+		DisplayName:	"Go",
+		Extension:		".go",
+		Compile:		[]string{ "/usr/local/go/bin/go", "build", "{flags}", "-o", "{exe}", "{src}" },
+		ReservesMemory:	true,
+	},
+	LanguageRust: {
+		Id:				LanguageRust,
+		Name:			"rust",
+		DisplayName:	"Rust",
+		Extension:		".rs",
+		Compile:		[]string{ "/usr/bin/rustc", "--edition", "2021", "{flags}", "-o", "{exe}", "{src}" },
+	},
+	LanguageJavaScript: {
+		Id:					LanguageJavaScript,
+		Name:				"javascript",
+		DisplayName:		"JavaScript (Node.js)",
+		Extension:			".js",
+		Compile:			[]string{ "/usr/bin/node", "--check", "{src}" },
+		Run:				[]string{ "/usr/bin/node", "{exe}" },
+		AllowedSyscall:		[]int32{ 435 },
+		ReservesMemory:		true,
+		TimeMultiplier:		2,
+	},
+}
+
+// Writes the bytecode to the second argument, exiting with the message alone on syntax errors.
+const pythonCompileScript = `import py_compile, sys
+try:
+    py_compile.compile(sys.argv[1], cfile=sys.argv[2], doraise=True)
+except py_compile.PyCompileError as e:
+    sys.exit(e.msg)`
+
+// Registers a language. Fields left empty keep the values of a language with the same id, if any.
+// If a compile command is given, it replaces the compiler of the language. A run command alone gives a language without
+// a compiler one that runs the source.
+func RegisterLanguage(lang Language) {
+	merged, ok := languageRegistry[lang.Id]
+	if !ok {
+		merged = Language{ Id: lang.Id }
+	}
+	if lang.Name != "" {
+		merged.Name = lang.Name
+	}
+	if lang.DisplayName != "" {
+		merged.DisplayName = lang.DisplayName
+	}
+	if lang.Highlight != "" {
+		merged.Highlight = lang.Highlight
+	}
+	if lang.Extension != "" {
+		merged.Extension = lang.Extension
+	}
+	if lang.Source != "" {
+		merged.Source = lang.Source
+	}
+	if lang.Version != "" {
+		merged.Version = lang.Version
+	}
+	if len(lang.Compile) > 0 {
+		merged.Compile = lang.Compile
+	}
+	if len(lang.Run) > 0 {
+		merged.Run = lang.Run
+	}
+	if len(lang.AllowedSyscall) > 0 {
+		merged.AllowedSyscall = lang.AllowedSyscall
+	}
+	if lang.ReservesMemory {
+		merged.ReservesMemory = true
+	}
+	if lang.TimeMultiplier > 0 {
+		merged.TimeMultiplier = lang.TimeMultiplier
+	}
+	if lang.MemoryMultiplier > 0 {
+		merged.MemoryMultiplier = lang.MemoryMultiplier
+	}
+	if lang.Placeholder != "" {
+		merged.Placeholder = lang.Placeholder
+	}
+	languageRegistry[lang.Id] = merged
+	if _, ok := compilerMap[lang.Id]; len(merged.Compile) > 0 || (len(merged.Run) > 0 && !ok) {
+		compilerMap[lang.Id] = commandCompiler(merged)
+	}
+}
+
+// Looks up a registered language. Languages only known to ExtendCompiler are described by their id.
+func LookupLanguage(id uint8) (Language, bool) {
+	if lang, ok := languageRegistry[id]; ok {
+		return lang, true
+	}
+	if _, ok := compilerMap[id]; ok {
+		name := strconv.Itoa(int(id))
+		return Language{ Id: id, Name: name, DisplayName: name }, true
+	}
+	return Language{}, false
+}
+
+// Languages that can be compiled, ordered by id, with versions probed.
+// Commands and sandbox settings are left out, as they reveal the layout of the server.
+func ListLanguages() []Language {
+	languages := []Language{}
+	for id := range compilerMap {
+		lang, _ := LookupLanguage(id)
+		lang.Version = lang.version()
+		lang.Compile, lang.Run, lang.AllowedSyscall, lang.ReservesMemory = nil, nil, nil, false
+		if lang.Highlight == "" {
+			lang.Highlight = lang.Name
+		}
+		languages = append(languages, lang)
+	}
+	slices.SortFunc(languages, func(a, b Language) int {
+		return int(a.Id) - int(b.Id)
+	})
+	return languages
+}
+
+// Whether the programs of both commands exist.
+func (l Language) installed() bool {
+	for _, command := range [][]string{ l.Compile, l.Run } {
+		if len(command) > 0 {
+			if _, err := os.Stat(command[0]); err != nil {
+				return false
+			}
+		}
+	}
+	return len(l.Compile) > 0 || len(l.Run) > 0
+}
+
+// Command running a program compiled by the compiler of this language.
+func (l Language) command(program string) Command {
+	return Command{ Program: program, Argv: l.Run, AllowedSyscall: l.AllowedSyscall, ReservesMemory: l.ReservesMemory }
+}
+
+// Version of the toolchain, or an empty string if unknown.
+func (l Language) version() string {
+	if l.Version != "" {
+		return l.Version
+	}
+	if version_of, ok := compilerVersionMap[l.Id]; ok {
+		return version_of()
+	}
+	return ""
+}
+
+// Applies the limit multipliers of the language to a data point.
+func (l Language) scale(point DataPoint) DataPoint {
+	if l.TimeMultiplier > 0 {
+		wall_limit := point.WallLimit()
+		point.CpuTimeLimit = int(float64(point.CpuLimit()) * l.TimeMultiplier)
+		point.WallTimeLimit = int(float64(wall_limit) * l.TimeMultiplier)
+	}
+	if l.MemoryMultiplier > 0 {
+		point.MemoryLimit = int(float64(point.MemoryLimit) * l.MemoryMultiplier)
+	}
+	return point
+}
+
+// Compiler running the compile command of a language.
+func commandCompiler(lang Language) Compiler {
+	return func(code string, flags []string) (string, CompileResult) {
+		folder, err := newCompileFolder()
+		if err != nil {
+			return "", CompileResult{ Compiler: lang.Name, Error: "compile folder could not be created" }
+		}
+		defer os.RemoveAll(folder)
+		src_path, exe_path := filepath.Join(folder, "src"), filepath.Join(folder, "exe")
+		if lang.Source != "" {
+			os.Mkdir(src_path, 0o777)
+			src_path = filepath.Join(src_path, lang.Source)
+		} else {
+			src_path += lang.Extension
+		}
+		os.WriteFile(src_path, ([]byte)(code), 0o777)
+		if len(lang.Compile) == 0 {
+			return takeExecutable(src_path, CompileResult{ Ok: true, Compiler: lang.Name })
+		}
+		argv := []string{}
+		for _, arg := range lang.Compile {
+			if arg == "{flags}" {
+				argv = append(argv, flags...)
+				continue
+			}
+			arg = strings.ReplaceAll(arg, "{src}", src_path)
+			argv = append(argv, strings.ReplaceAll(arg, "{exe}", exe_path))
+		}
+		result := RunCompiler(lang.Name, argv, folder)
+		if !result.Ok {
+			return "", result
+		}
+		if _, err := os.Stat(exe_path); err != nil {
+			exe_path = src_path
+		}
+		return takeExecutable(exe_path, result)
+	}
+}
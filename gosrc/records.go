@@ -15,9 +15,13 @@ type Record struct {
 // Record per user per objective.
 type RecordEntry struct {
 	// User code.
-	Code	[]string	`json:"code"`
+	Code		[]string	`json:"code"`
+	// Programming language of the code.
+	Language	uint8		`json:"language"`
 	// Amount of data points passed.
-	Passed	int			`json:"passed"`
+	Passed		int			`json:"passed"`
 	// Amount of data points in total.
-	Total	int			`json:"total"`
+	Total		int			`json:"total"`
+	// Score earned, see Objective.Score.
+	Score		float64		`json:"score"`
 }
\ No newline at end of file
@@ -1,12 +1,28 @@
+#include <errno.h>
+#include <fcntl.h>
+#include <limits.h>
+#include <linux/audit.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+#include <linux/securebits.h>
 #include <pthread.h>
+#include <sched.h>
 #include <signal.h>
+#include <stddef.h>
 #include <stdlib.h>
+#include <string.h>
+#include <sys/mount.h>
+#include <sys/prctl.h>
 #include <sys/ptrace.h>
 #include <sys/resource.h>
+#include <sys/stat.h>
+#include <sys/statvfs.h>
 #include <sys/types.h>
 #include <sys/user.h>
 #include <sys/wait.h>
 #include <syscall.h>
+#include <time.h>
 #include <unistd.h>
 
 #include <stdio.h>
@@ -16,152 +32,585 @@
 #define RC_TLE 2
 #define RC_MLE 3
 #define RC_SE 4
+#define RC_OLE 5
 #define SIZEOFCFG sizeof(exec_cfg)
-
-typedef struct _timeout_indicator {
-    int thread_id;
-    pid_t process_id;
-    char flag;
-    struct _timeout_indicator *next;
-} timeout_indicator, *timeout_list;
+#define X32_SYSCALL_BIT 0x40000000
+#define ISOLATE_FLAGS (CLONE_NEWUSER | CLONE_NEWNS | CLONE_NEWNET | CLONE_NEWPID | CLONE_NEWIPC | CLONE_NEWUTS)
 
 typedef struct exec_cfg {
     int stdin_fd;
     int stdout_fd;
-    int time_limit;
+    int stderr_fd;
+    int cpu_time_limit;
+    int wall_time_limit;
     int memory_limit;
+    int data_limit_only;
     int *disallowed_syscall;
     int disallowed_syscall_count;
+    int use_ptrace;
+    char *cgroup_procs;
+    char *rootfs;
+    int tmpfs_size;
+    char *program;
+    char *workdir;
+    char *input_file;
+    char *output_file;
+    int file_size_limit;
+    char **hidden;
+    int hidden_count;
+    char *visible;
 } exec_cfg;
 
 typedef struct exec_res {
     int code;
     float exec_time;
+    float wall_time;
     int exec_mem;
     int syscall;
     int termsig;
+    int exit_code;
 } exec_res;
 
-timeout_list t_list = NULL;
+#define WATCHDOG_TICK_NS 5000000
+#define WATCHDOG_CPU 1
+#define WATCHDOG_WALL 2
+
+// Per-run timer. It runs on its own thread and kills the child once either limit is exceeded.
+typedef struct watchdog {
+    int pidfd;
+    pid_t target;
+    int cpu_time_limit;
+    int wall_time_limit;
+    struct timespec start;
+    char done;
+    char fired;
+    pthread_mutex_t mutex;
+    pthread_cond_t cond;
+} watchdog;
+
+double timeval_seconds(struct timeval tv) {
+    return tv.tv_sec + (double)tv.tv_usec / 1000000;
+}
+
+double elapsed_since(struct timespec *start) {
+    struct timespec now;
+    clock_gettime(CLOCK_MONOTONIC, &now);
+    return (now.tv_sec - start->tv_sec) + (double)(now.tv_nsec - start->tv_nsec) / 1000000000;
+}
 
-pthread_mutex_t mutex = PTHREAD_MUTEX_INITIALIZER;
+// CPU time (user + system) of a process in seconds, or -1 if it is gone.
+double cpu_time_of(pid_t pid) {
+    clockid_t clock;
+    struct timespec ts;
+    if (clock_getcpuclockid(pid, &clock) != 0 || clock_gettime(clock, &ts) < 0) return -1;
+    return ts.tv_sec + (double)ts.tv_nsec / 1000000000;
+}
 
-void start_execute(int thread_id, pid_t process_id) {
-    pthread_mutex_lock(&mutex);
-    timeout_indicator *ti = malloc(sizeof(timeout_indicator));
-    ti->thread_id = thread_id;
-    ti->process_id = process_id;
-    ti->flag = 0;
-    ti->next = NULL;
-    if (t_list == NULL) {
-        t_list = ti;
+void *watchdog_run(void *arg) {
+    watchdog *wd = arg;
+    struct timespec tick;
+    pthread_mutex_lock(&wd->mutex);
+    while (!wd->done) {
+        clock_gettime(CLOCK_MONOTONIC, &tick);
+        tick.tv_nsec += WATCHDOG_TICK_NS;
+        if (tick.tv_nsec >= 1000000000) {
+            tick.tv_sec++;
+            tick.tv_nsec -= 1000000000;
+        }
+        pthread_cond_timedwait(&wd->cond, &wd->mutex, &tick);
+        if (wd->done) break;
+        if (wd->wall_time_limit > 0 && elapsed_since(&wd->start) * 1000 > wd->wall_time_limit) {
+            wd->fired = WATCHDOG_WALL;
+        }
+        else if (wd->cpu_time_limit > 0 && wd->target && cpu_time_of(wd->target) * 1000 > wd->cpu_time_limit) {
+            wd->fired = WATCHDOG_CPU;
+        }
+        if (wd->fired) {
+            // a pidfd cannot refer to a recycled pid, unlike kill()
+            syscall(SYS_pidfd_send_signal, wd->pidfd, SIGKILL, NULL, 0);
+            break;
+        }
     }
-    else {
-        timeout_indicator *cur;
-        for (cur = t_list; cur->next; cur = cur->next);
-        cur->next = ti;
+    pthread_mutex_unlock(&wd->mutex);
+    return NULL;
+}
+
+int start_watchdog(watchdog *wd, pthread_t *thread, pid_t child, exec_cfg *cfg) {
+    pthread_condattr_t attr;
+    memset(wd, 0, sizeof(watchdog));
+    wd->pidfd = syscall(SYS_pidfd_open, child, 0);
+    if (wd->pidfd < 0) return -1;
+    wd->cpu_time_limit = cfg->cpu_time_limit;
+    wd->wall_time_limit = cfg->wall_time_limit;
+    clock_gettime(CLOCK_MONOTONIC, &wd->start);
+    pthread_mutex_init(&wd->mutex, NULL);
+    pthread_condattr_init(&attr);
+    pthread_condattr_setclock(&attr, CLOCK_MONOTONIC);
+    pthread_cond_init(&wd->cond, &attr);
+    pthread_condattr_destroy(&attr);
+    if (pthread_create(thread, NULL, &watchdog_run, wd) != 0) {
+        close(wd->pidfd);
+        return -1;
     }
-    pthread_mutex_unlock(&mutex);
+    return 0;
 }
 
-pid_t set_timeout_for(int thread_id) {
-    pthread_mutex_lock(&mutex);
-    for (timeout_indicator *cur = t_list; cur; cur = cur->next) {
-        if (cur->thread_id == thread_id) {
-            cur->flag = 1;
-            pthread_mutex_unlock(&mutex);
-            return cur->process_id;
-        }
+void set_watchdog_target(watchdog *wd, pid_t target) {
+    pthread_mutex_lock(&wd->mutex);
+    wd->target = target;
+    pthread_mutex_unlock(&wd->mutex);
+}
+
+// Returns the limit that was exceeded, if any.
+char check_watchdog(watchdog *wd) {
+    pthread_mutex_lock(&wd->mutex);
+    char fired = wd->fired;
+    pthread_mutex_unlock(&wd->mutex);
+    return fired;
+}
+
+void stop_watchdog(watchdog *wd, pthread_t thread) {
+    pthread_mutex_lock(&wd->mutex);
+    wd->done = 1;
+    pthread_cond_signal(&wd->cond);
+    pthread_mutex_unlock(&wd->mutex);
+    pthread_join(thread, NULL);
+    pthread_cond_destroy(&wd->cond);
+    pthread_mutex_destroy(&wd->mutex);
+    close(wd->pidfd);
+}
+
+static const int open_syscalls[] = { SYS_open, SYS_openat, SYS_creat, SYS_openat2 };
+#define OPEN_SYSCALL_COUNT (sizeof(open_syscalls) / sizeof(open_syscalls[0]))
+
+// Absolute paths readable in file I/O mode outside of cfg->rootfs, as needed by the loader and by runtimes.
+// Entries ending with '/' stand for everything under them.
+static const char *readable_paths[] = {
+    "/lib/", "/lib64/", "/usr/lib/", "/usr/lib64/", "/usr/local/lib/", "/usr/share/", "/usr/bin/",
+    // probed by Python at startup
+    "/usr/pyvenv.cfg",
+    "/etc/ld.so.cache", "/etc/localtime", "/etc/ssl/openssl.cnf", "/etc/ssl/certs/",
+    "/dev/null", "/dev/zero", "/dev/random", "/dev/urandom",
+    "/proc/cpuinfo", "/proc/meminfo", "/proc/stat",
+    "/proc/self/maps", "/proc/self/status", "/proc/self/cgroup", "/proc/self/mountinfo",
+    "/sys/devices/system/cpu/", "/sys/kernel/mm/transparent_hugepage/", "/sys/fs/cgroup/",
+};
+#define READABLE_PATH_COUNT (sizeof(readable_paths) / sizeof(readable_paths[0]))
+
+// Amount of syscalls handed over to the tracer by the seccomp filter.
+int traced_syscall_count(exec_cfg *cfg) {
+    return cfg->disallowed_syscall_count + (cfg->workdir ? OPEN_SYSCALL_COUNT : 0);
+}
+
+// Builds a seccomp filter which hands every disallowed syscall over to the tracer.
+// In file I/O mode, file opens are handed over as well to be checked by check_open.
+// Layout: arch check, x32 check and its trace, one jump per syscall, allow, trace.
+// The x32 check comes first with a constant offset, as jump offsets are only 8 bits wide.
+void build_filter(exec_cfg *cfg, struct sock_fprog *prog) {
+    int n = traced_syscall_count(cfg);
+    int *traced = malloc(sizeof(int) * n);
+    if (cfg->disallowed_syscall_count > 0) {
+        memcpy(traced, cfg->disallowed_syscall, sizeof(int) * cfg->disallowed_syscall_count);
+    }
+    for (int j = cfg->disallowed_syscall_count; j < n; j++) {
+        traced[j] = open_syscalls[j - cfg->disallowed_syscall_count];
+    }
+    struct sock_filter *filter = malloc(sizeof(struct sock_filter) * (n + 8));
+    int i = 0;
+    filter[i++] = (struct sock_filter)BPF_STMT(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, arch));
+    filter[i++] = (struct sock_filter)BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, AUDIT_ARCH_X86_64, 1, 0);
+    filter[i++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_KILL_PROCESS);
+    filter[i++] = (struct sock_filter)BPF_STMT(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, nr));
+    filter[i++] = (struct sock_filter)BPF_JUMP(BPF_JMP | BPF_JGE | BPF_K, X32_SYSCALL_BIT, 0, 1);
+    filter[i++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_TRACE);
+    for (int j = 0; j < n; j++) {
+        filter[i++] = (struct sock_filter)BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, traced[j], n - j, 0);
+    }
+    filter[i++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_ALLOW);
+    filter[i++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_TRACE);
+    free(traced);
+    prog->len = i;
+    prog->filter = filter;
+}
+
+char is_disallowed(exec_cfg *cfg, long nr) {
+    for (int i = 0; i < cfg->disallowed_syscall_count; i++) {
+        if (cfg->disallowed_syscall[i] == nr) return 1;
     }
-    pthread_mutex_unlock(&mutex);
     return 0;
 }
 
-void clear_timeout_for(int thread_id) {
-    pthread_mutex_lock(&mutex);
-    timeout_indicator *cur;
-    for (cur = t_list; cur; cur = cur->next) {
-        if (cur->thread_id == thread_id) break;
+char is_open(long nr) {
+    for (int i = 0; i < OPEN_SYSCALL_COUNT; i++) {
+        if (open_syscalls[i] == nr) return 1;
     }
-    if (cur == NULL) {
-        pthread_mutex_unlock(&mutex);
-        return;
+    return 0;
+}
+
+// Reads a NUL-terminated string from the memory of a stopped tracee. Returns -1 if it does not fit in size bytes.
+int read_string(pid_t pid, unsigned long addr, char *buf, int size) {
+    for (int i = 0; i + sizeof(long) <= size; i += sizeof(long)) {
+        errno = 0;
+        long word = ptrace(PTRACE_PEEKDATA, pid, addr + i, NULL);
+        if (errno) return -1;
+        memcpy(buf + i, &word, sizeof(long));
+        if (memchr(&word, 0, sizeof(long))) return 0;
     }
-    if (cur == t_list) {
-        t_list = cur->next;
-        free(cur);
+    return -1;
+}
+
+// Whether path names the program or, if the program is a directory, a file within it.
+char is_program(char *path, exec_cfg *cfg) {
+    char exe[PATH_MAX];
+    if (!cfg->program) return 0;
+    if (cfg->rootfs) {
+        char *name = strrchr(cfg->program, '/');
+        snprintf(exe, sizeof(exe), "/tmp/%s", name ? name + 1 : cfg->program);
     }
     else {
-        timeout_indicator *prev;
-        for (prev = t_list; prev->next != cur; prev = prev->next);
-        prev->next = cur->next;
-        free(cur);
+        snprintf(exe, sizeof(exe), "%s", cfg->program);
     }
-    pthread_mutex_unlock(&mutex);
+    size_t len = strlen(exe);
+    return strncmp(path, exe, len) == 0 && (path[len] == 0 || path[len] == '/');
 }
 
-char check_timeout_for(int thread_id) {
-    pthread_mutex_lock(&mutex);
-    for (timeout_indicator *cur = t_list; cur; cur = cur->next) {
-        if (cur->thread_id == thread_id && cur->flag) {
-            pthread_mutex_unlock(&mutex);
+// Whether an absolute path may be opened for reading in file I/O mode. Within cfg->rootfs, which holds nothing
+// but the runtimes, any path may be read; otherwise only the program and readable_paths.
+char is_readable(char *path, exec_cfg *cfg) {
+    size_t path_len = strlen(path);
+    // paths climbing out of a readable directory
+    if (path[0] != '/' || strstr(path, "/../") || (path_len >= 3 && strcmp(path + path_len - 3, "/..") == 0)) return 0;
+    if (cfg->rootfs || is_program(path, cfg)) return 1;
+    for (int i = 0; i < READABLE_PATH_COUNT; i++) {
+        size_t len = strlen(readable_paths[i]);
+        if (readable_paths[i][len - 1] == '/' ? strncmp(path, readable_paths[i], len) == 0 : strcmp(path, readable_paths[i]) == 0) {
             return 1;
         }
     }
-    pthread_mutex_unlock(&mutex);
     return 0;
 }
 
-int gettid() {
-    return syscall(SYS_gettid);
+// Verdicts of check_open.
+#define OPEN_REJECTED 0
+#define OPEN_ALLOWED 1
+// the open fails with EACCES, as runtimes probe for optional files such as package.json
+#define OPEN_DENIED 2
+
+// Checks a file open in file I/O mode. Relative paths may only name the input or output file,
+// and only the output file may be opened for writing. Other read-only opens are allowed if is_readable and denied otherwise.
+char check_open(pid_t pid, struct user_regs_struct *regs, exec_cfg *cfg) {
+    char path[PATH_MAX];
+    unsigned long addr;
+    long flags;
+    switch (regs->orig_rax) {
+        case SYS_open:
+            addr = regs->rdi;
+            flags = regs->rsi;
+            break;
+        case SYS_openat:
+            addr = regs->rsi;
+            flags = regs->rdx;
+            break;
+        case SYS_creat:
+            addr = regs->rdi;
+            flags = O_WRONLY | O_CREAT | O_TRUNC;
+            break;
+        default:
+            // openat2 keeps its flags behind another pointer, and libc never uses it
+            return OPEN_REJECTED;
+    }
+    if (read_string(pid, addr, path, sizeof(path)) < 0) return OPEN_REJECTED;
+    char *name = strncmp(path, "./", 2) == 0 ? path + 2 : path;
+    if (cfg->output_file && strcmp(name, cfg->output_file) == 0) return OPEN_ALLOWED;
+    if ((flags & O_ACCMODE) != O_RDONLY || (flags & (O_CREAT | O_TRUNC))) return OPEN_REJECTED;
+    if (cfg->input_file && strcmp(name, cfg->input_file) == 0) return OPEN_ALLOWED;
+    return is_readable(path, cfg) ? OPEN_ALLOWED : OPEN_DENIED;
+}
+
+// Kills every process of the run and reaps them so that no zombie is left behind.
+// The group is signalled even if its leader is gone, as processes forked by the program may be left in it;
+// its id cannot be reused while any of them is alive.
+void kill_child(pid_t child) {
+    int status;
+    kill(-child, SIGKILL);
+    while (waitpid(-child, &status, __WALL) > 0 || errno == EINTR);
+}
+
+int write_file(char *path, char *content) {
+    int fd = open(path, O_WRONLY);
+    if (fd < 0) return -1;
+    int n = write(fd, content, strlen(content));
+    close(fd);
+    return n < 0 ? -1 : 0;
+}
+
+// Maps root of the child's user namespace to the current user.
+int map_user(pid_t child) {
+    char path[64], map[64];
+    snprintf(path, sizeof(path), "/proc/%d/setgroups", child);
+    if (write_file(path, "deny") < 0) return -1;
+    snprintf(path, sizeof(path), "/proc/%d/uid_map", child);
+    snprintf(map, sizeof(map), "0 %d 1", getuid());
+    if (write_file(path, map) < 0) return -1;
+    snprintf(path, sizeof(path), "/proc/%d/gid_map", child);
+    snprintf(map, sizeof(map), "0 %d 1", getgid());
+    return write_file(path, map);
+}
+
+// Remounts a bind mount read-only, keeping the flags locked by the parent namespace.
+int remount_readonly(char *target) {
+    struct statvfs st;
+    if (statvfs(target, &st) < 0) return -1;
+    unsigned long flags = MS_BIND | MS_REMOUNT | MS_RDONLY | MS_NOSUID;
+    if (st.f_flag & ST_NODEV) flags |= MS_NODEV;
+    if (st.f_flag & ST_NOEXEC) flags |= MS_NOEXEC;
+    if (st.f_flag & ST_NOATIME) flags |= MS_NOATIME;
+    if (st.f_flag & ST_NODIRATIME) flags |= MS_NODIRATIME;
+    if (st.f_flag & ST_RELATIME) flags |= MS_RELATIME;
+    return mount(NULL, target, NULL, flags, NULL);
+}
+
+// Binds a file or directory onto an empty one created at target.
+int bind_file(char *source, char *target, char readonly) {
+    struct stat st;
+    if (stat(source, &st) < 0) return -1;
+    if (S_ISDIR(st.st_mode)) {
+        if (mkdir(target, 0755) < 0) return -1;
+    }
+    else {
+        int fd = open(target, O_CREAT | O_WRONLY, 0755);
+        if (fd < 0) return -1;
+        close(fd);
+    }
+    if (mount(source, target, NULL, MS_BIND | MS_REC, NULL) < 0) return -1;
+    return readonly ? remount_readonly(target) : 0;
+}
+
+// Pivots into a read-only bind of cfg->rootfs, with a private tmpfs mounted on /tmp as working directory.
+// cfg->program is bound into the tmpfs under its own name, as well as the input (read-only) and output files in file I/O mode.
+int enter_rootfs(exec_cfg *cfg) {
+    char source[PATH_MAX], target[PATH_MAX], options[64];
+    if (mount(NULL, "/", NULL, MS_REC | MS_PRIVATE, NULL) < 0) return -1;
+    if (mount(cfg->rootfs, cfg->rootfs, NULL, MS_BIND | MS_REC, NULL) < 0) return -1;
+    if (remount_readonly(cfg->rootfs) < 0) return -1;
+    snprintf(target, sizeof(target), "%s/tmp", cfg->rootfs);
+    snprintf(options, sizeof(options), "size=%d,mode=0777", cfg->tmpfs_size);
+    if (mount("tmpfs", target, "tmpfs", MS_NOSUID | MS_NODEV, options) < 0) return -1;
+    if (cfg->program) {
+        char *name = strrchr(cfg->program, '/');
+        name = name ? name + 1 : cfg->program;
+        snprintf(target, sizeof(target), "%s/tmp/%s", cfg->rootfs, name);
+        if (bind_file(cfg->program, target, 1) < 0) return -1;
+    }
+    if (cfg->input_file) {
+        snprintf(source, sizeof(source), "%s/%s", cfg->workdir, cfg->input_file);
+        snprintf(target, sizeof(target), "%s/tmp/%s", cfg->rootfs, cfg->input_file);
+        if (bind_file(source, target, 1) < 0) return -1;
+    }
+    if (cfg->output_file) {
+        snprintf(source, sizeof(source), "%s/%s", cfg->workdir, cfg->output_file);
+        snprintf(target, sizeof(target), "%s/tmp/%s", cfg->rootfs, cfg->output_file);
+        if (bind_file(source, target, 0) < 0) return -1;
+    }
+    // stacking the old root under the new one avoids the need of a put_old directory
+    if (chdir(cfg->rootfs) < 0) return -1;
+    if (syscall(SYS_pivot_root, ".", ".") < 0) return -1;
+    if (umount2(".", MNT_DETACH) < 0) return -1;
+    if (chdir("/tmp") < 0) return -1;
+    sethostname("zdotoj", 6);
+    return 0;
+}
+
+// Creates every missing directory of an absolute path.
+int make_path(char *path) {
+    char prefix[PATH_MAX];
+    for (char *slash = strchr(path + 1, '/'); ; slash = strchr(slash + 1, '/')) {
+        size_t length = slash ? (size_t)(slash - path) : strlen(path);
+        if (length >= sizeof(prefix)) return -1;
+        memcpy(prefix, path, length);
+        prefix[length] = 0;
+        if (mkdir(prefix, 0755) < 0 && errno != EEXIST) return -1;
+        if (!slash) return 0;
+    }
+}
+
+// Covers each of cfg->hidden that exists, directories with a private tmpfs and files with /dev/null,
+// binding cfg->visible back at its own path. A proc file system of the new pid namespace replaces /proc, as the host one
+// reaches the hidden paths through /proc/<pid>/root; an empty one is mounted if that is not permitted.
+int hide_paths(exec_cfg *cfg) {
+    char source[64], options[64];
+    int visible_fd = -1;
+    struct stat st;
+    if (mount(NULL, "/", NULL, MS_REC | MS_PRIVATE, NULL) < 0) return -1;
+    // opened beforehand, as the path may lie within a hidden directory
+    if (cfg->visible && (visible_fd = open(cfg->visible, O_PATH | O_DIRECTORY)) < 0) return -1;
+    snprintf(options, sizeof(options), "size=%d,mode=0777", cfg->tmpfs_size);
+    for (int i = 0; i < cfg->hidden_count; i++) {
+        if (stat(cfg->hidden[i], &st) < 0) continue;
+        if (S_ISDIR(st.st_mode)) {
+            if (mount("tmpfs", cfg->hidden[i], "tmpfs", MS_NOSUID | MS_NODEV, options) < 0) return -1;
+        }
+        else if (mount("/dev/null", cfg->hidden[i], NULL, MS_BIND, NULL) < 0) return -1;
+    }
+    if (visible_fd >= 0) {
+        snprintf(source, sizeof(source), "/proc/self/fd/%d", visible_fd);
+        if (make_path(cfg->visible) < 0) return -1;
+        if (mount(source, cfg->visible, NULL, MS_BIND, NULL) < 0) return -1;
+        close(visible_fd);
+    }
+    if (mount("proc", "/proc", "proc", MS_NOSUID | MS_NODEV | MS_NOEXEC, NULL) < 0 &&
+        mount("tmpfs", "/proc", "tmpfs", MS_RDONLY | MS_NOSUID | MS_NODEV | MS_NOEXEC, "size=0") < 0) return -1;
+    // the working directory may lie within a hidden one, which stays reachable through it
+    if (chdir(cfg->visible ? cfg->visible : "/") < 0) return -1;
+    sethostname("zdotoj", 6);
+    return 0;
+}
+
+// Whether the program runs in namespaces of its own, either within cfg->rootfs or with paths hidden.
+int isolated(exec_cfg *cfg) {
+    return cfg->rootfs || cfg->hidden_count > 0;
 }
 
-void timeout_callback(int sig) {
-    pid_t tid = gettid();
-    pid_t pid = set_timeout_for(tid);
-    if (pid) kill(pid, SIGKILL);
+// Drops every capability held in the user namespace, so that root inside cannot undo the mounts.
+int drop_capabilities() {
+    struct __user_cap_header_struct header = { _LINUX_CAPABILITY_VERSION_3, 0 };
+    struct __user_cap_data_struct data[2];
+    memset(data, 0, sizeof(data));
+    for (int cap = 0; cap < 64; cap++) {
+        if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) < 0 && errno != EINVAL) return -1;
+    }
+    if (prctl(PR_SET_SECUREBITS,
+        SECBIT_NOROOT | SECBIT_NOROOT_LOCKED | SECBIT_NO_SETUID_FIXUP | SECBIT_NO_SETUID_FIXUP_LOCKED,
+        0, 0, 0) < 0) return -1;
+    return syscall(SYS_capset, &header, data);
 }
 
-exec_res *execute(char *path, exec_cfg *cfg) {
-    pid_t child = fork();
+// Runs argv[0] with given NULL-terminated arguments. Returns NULL if the sandbox could not be set up.
+exec_res *execute(char **argv, exec_cfg *cfg) {
+    struct sock_fprog prog = { 0, NULL };
+    int sync_fd[2];
+    // nothing to trap means no filter at all, so trusted programs run on kernels without seccomp too
+    if (!cfg->use_ptrace && traced_syscall_count(cfg) > 0) {
+        if (traced_syscall_count(cfg) > 255) return NULL;
+        build_filter(cfg, &prog);
+    }
+    if (pipe(sync_fd) < 0) {
+        free(prog.filter);
+        return NULL;
+    }
+    pid_t child;
+    if (isolated(cfg)) {
+        child = syscall(SYS_clone, SIGCHLD | ISOLATE_FLAGS, NULL, NULL, NULL, 0);
+    }
+    else {
+        child = fork();
+    }
+    if (child < 0) {
+        close(sync_fd[0]);
+        close(sync_fd[1]);
+        free(prog.filter);
+        return NULL;
+    }
     if (child) {
         int status, memory_used = 0;
+        // the program itself; in isolated mode this is the process forked by child, which acts as init
+        pid_t target = 0;
+        // whether the next syscall stop of the target is a syscall exit rather than an entry
+        char in_syscall = 0;
+        // whether the syscall the target is in was skipped by check_open, to fail with EACCES
+        char denied = 0;
         struct user_regs_struct regs;
         struct rusage ru;
-        exec_res *res = malloc(sizeof(exec_res));
-        wait(&status);
+        watchdog wd;
+        pthread_t wd_thread;
+        exec_res *res = calloc(1, sizeof(exec_res));
+        long options = PTRACE_O_EXITKILL | PTRACE_O_TRACEEXEC | PTRACE_O_TRACESYSGOOD;
+        if (!cfg->use_ptrace) options |= PTRACE_O_TRACESECCOMP;
+        if (isolated(cfg)) options |= PTRACE_O_TRACEFORK;
+
+        close(sync_fd[0]);
+        free(prog.filter);
+        setpgid(child, child);
+        if (isolated(cfg) && map_user(child) < 0) {
+            close(sync_fd[1]);
+            kill_child(child);
+            free(res);
+            return NULL;
+        }
+        ptrace(PTRACE_SEIZE, child, NULL, options);
+        // a single byte rather than EOF, as concurrent forks may hold copies of the write end
+        write(sync_fd[1], "", 1);
+        close(sync_fd[1]);
         
-        if (cfg->time_limit > 0) {
-            alarm(cfg->time_limit);
-            signal(SIGALRM, &timeout_callback);
+        if (start_watchdog(&wd, &wd_thread, child, cfg) < 0) {
+            kill_child(child);
+            free(res);
+            return NULL;
         }
 
-        start_execute(gettid(), child);
-
         for(;;) {
-            ptrace(PTRACE_SYSCALL, child, NULL, NULL);
-            wait4(child, &status, WUNTRACED, &ru);
+            pid_t pid = wait4(-child, &status, __WALL, &ru);
+            if (pid < 0) {
+                if (errno == EINTR) continue;
+                free(res);
+                res = NULL;
+                break;
+            }
+
+            char is_init = isolated(cfg) && pid == child;
+            int resume = cfg->use_ptrace && pid == target ? PTRACE_SYSCALL : PTRACE_CONT;
+
+            if (is_init) {
+                if (WIFEXITED(status) || WIFSIGNALED(status)) {
+                    if (check_watchdog(&wd)) {
+                        res->code = RC_TLE;
+                    }
+                    else {
+                        // init only exits by itself if the sandbox could not be set up
+                        free(res);
+                        res = NULL;
+                    }
+                    break;
+                }
+                ptrace(PTRACE_CONT, child, NULL, NULL);
+                continue;
+            }
+
+            if ((WIFEXITED(status) || WIFSIGNALED(status)) && pid != target) {
+                continue;
+            }
 
             memory_used = ru.ru_maxrss * 1000;
 
+            if (WIFEXITED(status) || WIFSIGNALED(status)) {
+                res->exec_time = timeval_seconds(ru.ru_utime) + timeval_seconds(ru.ru_stime);
+                res->wall_time = elapsed_since(&wd.start);
+            }
+
             if (WIFEXITED(status)) {
                 res->code = RC_OK;
-                res->exec_time = (double)ru.ru_utime.tv_usec / 1000000;
                 res->exec_mem = memory_used;
+                res->exit_code = WEXITSTATUS(status);
+                // the watchdog only samples, so the exact usage is checked once more
+                if ((cfg->cpu_time_limit > 0 && res->exec_time * 1000 > cfg->cpu_time_limit) ||
+                    (cfg->wall_time_limit > 0 && res->wall_time * 1000 > cfg->wall_time_limit)) {
+                    res->code = RC_TLE;
+                }
                 break;
             }
 
-            if (check_timeout_for(gettid())) {
-                ptrace(PTRACE_KILL, child, NULL, NULL);
+            if (check_watchdog(&wd)) {
                 res->code = RC_TLE;
                 break;
             }
 
             if (WIFSIGNALED(status)) {
-                ptrace(PTRACE_KILL, child, NULL, NULL);
                 if (WTERMSIG(status) == SIGXCPU) {
                     res->code = RC_TLE;
                 }
+                else if (WTERMSIG(status) == SIGXFSZ) {
+                    res->code = RC_OLE;
+                }
                 else if (WTERMSIG(status) == SIGSEGV && cfg->memory_limit > 0 && memory_used > cfg->memory_limit)
                 {
                     res->code = RC_MLE;
@@ -173,58 +622,164 @@ exec_res *execute(char *path, exec_cfg *cfg) {
                 break;
             }
 
-            if (WIFSTOPPED(status) && WSTOPSIG(status) != SIGTRAP && WSTOPSIG(status) != SIGCHLD) {
-                ptrace(PTRACE_KILL, child, NULL, NULL);
-                if (WSTOPSIG(status) == SIGXCPU) {
-                    res->code = RC_TLE;
+            int event = status >> 16;
+
+            if (event == PTRACE_EVENT_EXEC) {
+                // init never executes anything, so the first exec is always the program
+                if (!target) {
+                    target = pid;
+                    set_watchdog_target(&wd, target);
                 }
-                else if (WSTOPSIG(status) == SIGSEGV && cfg->memory_limit > 0 && memory_used > cfg->memory_limit) {
-                    res->code = RC_MLE;
+                // the exec event is reported inside execve, so the next syscall stop is its exit
+                in_syscall = 1;
+                ptrace(cfg->use_ptrace && pid == target ? PTRACE_SYSCALL : PTRACE_CONT, pid, NULL, NULL);
+                continue;
+            }
+
+            if (event == PTRACE_EVENT_SECCOMP) {
+                // syscalls issued before execv belong to the sandbox itself
+                if (!target) {
+                    ptrace(PTRACE_CONT, pid, NULL, NULL);
+                    continue;
                 }
-                else {
-                    res->code = RC_RE;
-                    res->termsig = WSTOPSIG(status);
+                ptrace(PTRACE_GETREGS, pid, NULL, &regs);
+                char verdict = OPEN_REJECTED;
+                if (cfg->workdir && is_open(regs.orig_rax) && !is_disallowed(cfg, regs.orig_rax)) {
+                    verdict = check_open(pid, &regs, cfg);
                 }
+                if (verdict == OPEN_DENIED) {
+                    // a skipped syscall returns whatever is left in rax
+                    regs.orig_rax = -1;
+                    regs.rax = -EACCES;
+                    ptrace(PTRACE_SETREGS, pid, NULL, &regs);
+                }
+                if (verdict != OPEN_REJECTED) {
+                    ptrace(PTRACE_CONT, pid, NULL, NULL);
+                    continue;
+                }
+                res->code = RC_SE;
+                res->syscall = regs.orig_rax;
                 break;
             }
 
-            ptrace(PTRACE_GETREGS, child, NULL, &regs);
+            if (event) {
+                ptrace(resume, pid, NULL, NULL);
+                continue;
+            }
 
-            char valid = 1;
-            for (int i = 0; i < cfg->disallowed_syscall_count; i++) {
-                if (cfg->disallowed_syscall[i] == regs.orig_rax) {
-                    valid = 0;
-                    break;
+            if (WIFSTOPPED(status) && WSTOPSIG(status) != (SIGTRAP | 0x80)) {
+                // signals are passed on, as runtimes such as Go and Java handle some of them (SIGURG, SIGSEGV) themselves;
+                // fatal ones are reported above once the program dies of them
+                ptrace(resume, pid, NULL, WSTOPSIG(status));
+                continue;
+            }
+
+            if (resume != PTRACE_SYSCALL) {
+                ptrace(PTRACE_CONT, pid, NULL, NULL);
+                continue;
+            }
+
+            // only entries are checked; each is followed by the exit of the same syscall
+            in_syscall = !in_syscall;
+            if (!in_syscall) {
+                if (denied) {
+                    // the return value of a skipped syscall is only settled at its exit
+                    ptrace(PTRACE_GETREGS, pid, NULL, &regs);
+                    regs.rax = -EACCES;
+                    ptrace(PTRACE_SETREGS, pid, NULL, &regs);
+                    denied = 0;
                 }
+                ptrace(PTRACE_SYSCALL, pid, NULL, NULL);
+                continue;
+            }
+
+            ptrace(PTRACE_GETREGS, pid, NULL, &regs);
+
+            char verdict = is_disallowed(cfg, regs.orig_rax) ? OPEN_REJECTED : OPEN_ALLOWED;
+            if (verdict == OPEN_ALLOWED && cfg->workdir && is_open(regs.orig_rax)) {
+                verdict = check_open(pid, &regs, cfg);
             }
-            if (!valid) {
-                ptrace(PTRACE_KILL, child, NULL, NULL);
-                waitpid(child, &status, WUNTRACED);
+            if (verdict == OPEN_REJECTED) {
                 res->code = RC_SE;
                 res->syscall = regs.orig_rax;
                 break;
             }
+            if (verdict == OPEN_DENIED) {
+                regs.orig_rax = -1;
+                ptrace(PTRACE_SETREGS, pid, NULL, &regs);
+                denied = 1;
+            }
+            ptrace(PTRACE_SYSCALL, pid, NULL, NULL);
         }
-        clear_timeout_for(gettid());
+        stop_watchdog(&wd, wd_thread);
+        kill_child(child);
         return res;
     }
     else {
+        char c;
+        close(sync_fd[1]);
+        read(sync_fd[0], &c, 1);
+        close(sync_fd[0]);
+        setpgid(0, 0);
+        if (cfg->cgroup_procs) {
+            // "0" moves the writing process itself
+            int procs_fd = open(cfg->cgroup_procs, O_WRONLY);
+            if (procs_fd < 0 || write(procs_fd, "0", 1) < 0) _exit(127);
+            close(procs_fd);
+        }
+        if (cfg->rootfs) {
+            if (enter_rootfs(cfg) < 0) _exit(127);
+        }
+        else if (cfg->hidden_count > 0) {
+            if (hide_paths(cfg) < 0) _exit(127);
+        }
+        else if (cfg->workdir && chdir(cfg->workdir) < 0) _exit(127);
         dup2(cfg->stdin_fd, STDIN_FILENO);
         dup2(cfg->stdout_fd, STDOUT_FILENO);
-        if (cfg->memory_limit > 0) {
+        dup2(cfg->stderr_fd, STDERR_FILENO);
+        // close-on-exec is not enough, since init never executes: any pipe end it kept would hold the pipe open
+        syscall(SYS_close_range, 3, ~0U, 0);
+        if (cfg->memory_limit > 0 && !cfg->cgroup_procs) {
             struct rlimit memory_limit;
             memory_limit.rlim_cur = memory_limit.rlim_max = cfg->memory_limit;
             setrlimit(RLIMIT_DATA, &memory_limit);
-            memory_limit.rlim_cur = memory_limit.rlim_max = cfg->memory_limit*2;
-            setrlimit(RLIMIT_AS, &memory_limit);
+            if (!cfg->data_limit_only) {
+                memory_limit.rlim_cur = memory_limit.rlim_max = cfg->memory_limit*2;
+                setrlimit(RLIMIT_AS, &memory_limit);
+            }
         }
-        if (cfg->time_limit > 0) {
+        if (cfg->cpu_time_limit > 0) {
+            // only a backstop in case the watchdog lags behind
             struct rlimit time_limit;
-            time_limit.rlim_cur = time_limit.rlim_max = cfg->time_limit;
+            time_limit.rlim_cur = cfg->cpu_time_limit / 1000 + 1;
+            time_limit.rlim_max = time_limit.rlim_cur + 1;
             setrlimit(RLIMIT_CPU, &time_limit);
         }
-        ptrace(PTRACE_TRACEME, 0, NULL, NULL);
-        execl(path, path, NULL);
+        // programs may now die of signals they do not handle, which must not leave core files behind
+        struct rlimit core_limit = { 0, 0 };
+        setrlimit(RLIMIT_CORE, &core_limit);
+        if (cfg->file_size_limit > 0) {
+            struct rlimit file_limit;
+            file_limit.rlim_cur = file_limit.rlim_max = cfg->file_size_limit;
+            setrlimit(RLIMIT_FSIZE, &file_limit);
+        }
+        if (isolated(cfg) && drop_capabilities() < 0) _exit(127);
+        if (prog.filter) {
+            prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0);
+            if (prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog) < 0) _exit(127);
+        }
+        if (isolated(cfg)) {
+            // pid 1 ignores signals it has no handler for, so the program runs as its child
+            pid_t grandchild = fork();
+            if (grandchild < 0) _exit(127);
+            if (grandchild) {
+                int status;
+                while (waitpid(grandchild, &status, 0) < 0 && errno == EINTR);
+                _exit(0);
+            }
+        }
+        execv(argv[0], argv);
+        _exit(127);
     }
     return NULL;
 }
\ No newline at end of file
@@ -1,7 +1,7 @@
 package main
 
 /*
-#cgo CFLAGS: -I.
+#cgo CFLAGS: -I. -D_GNU_SOURCE
 #cgo LDFLAGS: -Wl,--allow-multiple-definition
 #include <stdlib.h>
 #include "sandbox.c"
@@ -9,77 +9,494 @@ package main
 import "C"
 
 import (
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 	"unsafe"
 )
 
+// Size of the working directory in isolation mode, if not configured.
+const defaultTmpfsSize = 64 << 20
+
 // Represents the result of a native execution.
 type ExecResult struct {
 	// Status code.
 	Code       int		`json:"code"`
-	// Time cost in seconds.
+	// CPU time (user + system) in seconds.
 	ExecTime   float32	`json:"execTime"`
+	// Wall-clock time in seconds.
+	WallTime   float32	`json:"wallTime"`
 	// Memory usage in bytes.
 	ExecMemory int		`json:"execMemory"`
 	// Syscall number, if .Code == SE.
 	Syscall    int		`json:"syscall"`
 	// Termination signal number, if .Code == RE.
 	TermSig    int		`json:"termsig"`
+	// Exit status, if the program exited normally.
+	ExitCode   int		`json:"exitCode"`
+	// Standard error, truncated to Core.StderrLimit bytes.
+	Stderr     string	`json:"stderr,omitempty"`
+}
+
+// Command line of a program built by a compiler.
+type Command struct {
+	// Program built by the compiler, either an executable or a file or directory read by an interpreter.
+	Program			string
+	// Arguments, in which "{exe}" stands for the program. Just the program if empty.
+	Argv			[]string
+	// System calls exempted from Core.DisallowedSyscall, such as clone3 (435) for runtimes starting threads.
+	AllowedSyscall	[]int32
+	// Whether the program reserves address space far beyond its use, as the runtimes of Go, Java and Node.js do.
+	// Only the data segment is limited then, if memory is limited by rlimit.
+	ReservesMemory	bool
+}
+
+// Names of the files a program reads its input from and writes its output to, within its working directory.
+// Empty names stand for standard input and output.
+type FileIO struct {
+	// Name of the input file.
+	Input	string
+	// Name of the output file.
+	Output	string
+}
+
+// Whether standard input and output are used, so no working directory is needed.
+func (f FileIO) Standard() bool {
+	return f.Input == "" && f.Output == ""
+}
+
+// Checks that both names are plain file names, distinct from each other.
+func (f FileIO) Valid() bool {
+	plain := func(name string) bool {
+		return name == "" || (name != "." && name != ".." && filepath.Base(name) == name && removeNullChars(name) == name)
+	}
+	return plain(f.Input) && plain(f.Output) && (f.Input == "" || f.Input != f.Output)
 }
 
 // Executes given program in sandbox. Time cost, memory usage and system calls are monitored.
+// Disallowed system calls are trapped by a seccomp filter, unless Core.UsePtrace is set.
+// If Core.RootFS is set, the program runs in its own namespaces on a read-only copy of it.
+// Unless files is standard, the program runs in a working directory of its own and may only open the named files there;
+// other files may only be read if they belong to the system or Core.RootFS is set, and fail to open otherwise.
+// If interactor is not nil, it talks to the program in place of the fixed input, and the returned output is empty.
 func Execute(
-	path string,
+	command Command,
+	point DataPoint,
+	files FileIO,
+	interactor Interactor,
+) (string, ExecResult) {
+	return execute(command, point, files, interactor, execOptions{
+		confine:		true,
+		stderrLimit:	GetConfig().Core.StderrLimit,
+	})
+}
+
+// Options of a sandboxed run which do not come from a data point.
+type execOptions struct {
+	// Whether disallowed syscalls are trapped and the program is isolated as configured.
+	// Off for trusted programs such as compilers, which are only limited in resources.
+	confine			bool
+	// Amount of standard error kept. Zero keeps all.
+	stderrLimit		int
+	// Size limit of every file written, in bytes. Zero if unlimited.
+	fileSizeLimit	int
+	// Limit of processes. Core.MaxProcesses if zero.
+	maxProcesses	int
+	// Absolute paths covered by empty ones in namespaces of their own, for unconfined programs.
+	hidden			[]string
+	// Absolute path of a directory left visible, even within a hidden one. The working directory if set.
+	visible			string
+}
+
+// Runs a command in sandbox. Without a program, argv[0] is run as is. See Execute.
+func execute(
+	command Command,
 	point DataPoint,
+	files FileIO,
+	interactor Interactor,
+	opts execOptions,
 ) (string, ExecResult) {
-	disallowedSyscall := GetConfig().Core.DisallowedSyscall
+	disallowedSyscall := slices.DeleteFunc(slices.Clone(GetConfig().Core.DisallowedSyscall), func(nr int32) bool {
+		return slices.Contains(command.AllowedSyscall, nr)
+	})
+	if !opts.confine {
+		disallowedSyscall = nil
+	}
+	// isolation is enabled by configuring a root file system
+	rootfs := GetConfig().Core.RootFS
+	if !opts.confine {
+		rootfs = ""
+	}
+	if !files.Valid() || (interactor != nil && !files.Standard()) {
+		return "", ExecResult{ Code: IE }
+	}
+
+	argv := command.Argv
+	program := ""
+	if command.Program != "" {
+		var err error
+		// the program may leave the current directory, so its path has to be absolute
+		if program, err = filepath.Abs(command.Program); err != nil {
+			return "", ExecResult{ Code: IE }
+		}
+		if len(argv) == 0 {
+			argv = []string{ "{exe}" }
+		}
+		// bound into the working directory of the new root in isolation mode
+		exe := program
+		if rootfs != "" {
+			exe = "/tmp/" + filepath.Base(program)
+		}
+		argv = slices.Clone(argv)
+		for i := range argv {
+			argv[i] = strings.ReplaceAll(argv[i], "{exe}", exe)
+		}
+	}
+	if len(argv) == 0 {
+		return "", ExecResult{ Code: IE }
+	}
+
+	workdir := ""
+	if !files.Standard() {
+		var err error
+		if workdir, err = filepath.Abs(RandomFile(GetConfig().Core.TemporaryFolder)); err != nil {
+			return "", ExecResult{ Code: IE }
+		}
+		if os.Mkdir(workdir, 0o755) != nil {
+			return "", ExecResult{ Code: IE }
+		}
+		defer os.RemoveAll(workdir)
+		if files.Input != "" && os.WriteFile(filepath.Join(workdir, files.Input), ([]byte)(point.In), 0o644) != nil {
+			return "", ExecResult{ Code: IE }
+		}
+		// created beforehand, as it is bound into the new root in isolation mode
+		if files.Output != "" && os.WriteFile(filepath.Join(workdir, files.Output), nil, 0o644) != nil {
+			return "", ExecResult{ Code: IE }
+		}
+	}
 
 	stdin, stdin_parent, _ := os.Pipe()
 	stdout_parent, stdout, _ := os.Pipe()
-	defer stdout_parent.Close()
-	stdin_parent.WriteString(point.In)
-	stdin_parent.Close()
+	stderr_parent, stderr, _ := os.Pipe()
+	errput := drainOutput(stderr_parent, opts.stderrLimit, false)
+	var output *boundedOutput
+	if interactor != nil {
+		output = startInteraction(interactor, stdin_parent, stdout_parent, point.OutLimit())
+	} else {
+		// drained while running, as the program blocks once the pipe buffer is full
+		output = drainOutput(stdout_parent, point.OutLimit(), true)
+		// fed while running for the same reason; the write fails with EPIPE if the program exits early
+		go func() {
+			if files.Input == "" {
+				stdin_parent.WriteString(point.In)
+			}
+			stdin_parent.Close()
+		}()
+	}
 
 	cfg := (*C.struct_exec_cfg)(C.malloc(C.SIZEOFCFG))
 	cfg.stdin_fd = C.int(stdin.Fd())
 	cfg.stdout_fd = C.int(stdout.Fd())
-	cfg.time_limit = C.int(point.TimeLimit)
+	cfg.stderr_fd = C.int(stderr.Fd())
+	cfg.cpu_time_limit = C.int(point.CpuLimit())
+	cfg.wall_time_limit = C.int(point.WallLimit())
 	cfg.memory_limit = C.int(point.MemoryLimit)
-	cfg.disallowed_syscall = (*C.int)(&disallowedSyscall[0])
+	cfg.data_limit_only = 0
+	if command.ReservesMemory {
+		cfg.data_limit_only = 1
+	}
+	cfg.disallowed_syscall = nil
+	if len(disallowedSyscall) > 0 {
+		// copied, as the filtered list is only referenced from C memory, which the garbage collector does not see
+		syscalls := (*C.int)(C.malloc(C.size_t(len(disallowedSyscall)) * C.size_t(unsafe.Sizeof(C.int(0)))))
+		defer C.free(unsafe.Pointer(syscalls))
+		syscalls_slice := unsafe.Slice(syscalls, len(disallowedSyscall))
+		for i, nr := range disallowedSyscall {
+			syscalls_slice[i] = C.int(nr)
+		}
+		cfg.disallowed_syscall = syscalls
+	}
 	cfg.disallowed_syscall_count = C.int(len(disallowedSyscall))
+	cfg.use_ptrace = 0
+	if opts.confine && GetConfig().Core.UsePtrace {
+		cfg.use_ptrace = 1
+	}
+	cfg.cgroup_procs = nil
+	cfg.rootfs = nil
+	cfg.program = nil
+	cfg.workdir = nil
+	cfg.input_file = nil
+	cfg.output_file = nil
+	cfg.file_size_limit = C.int(opts.fileSizeLimit)
+	cfg.hidden = nil
+	cfg.hidden_count = 0
+	cfg.visible = nil
+	if files.Output != "" && point.OutLimit() > 0 {
+		// one byte past the limit, so that an exceeding output is never silently cut at the limit
+		cfg.file_size_limit = C.int(point.OutLimit() + 1)
+	}
 	defer C.free(unsafe.Pointer(cfg))
 
-	path_cstr := C.CString(path)
-	defer C.free(unsafe.Pointer(path_cstr))
+	if workdir != "" {
+		workdir_cstr := C.CString(workdir)
+		defer C.free(unsafe.Pointer(workdir_cstr))
+		cfg.workdir = workdir_cstr
+	}
+	if files.Input != "" {
+		input_cstr := C.CString(files.Input)
+		defer C.free(unsafe.Pointer(input_cstr))
+		cfg.input_file = input_cstr
+	}
+	if files.Output != "" {
+		output_cstr := C.CString(files.Output)
+		defer C.free(unsafe.Pointer(output_cstr))
+		cfg.output_file = output_cstr
+	}
 
-	res_ptr := C.execute(path_cstr, cfg)
-	defer C.free(unsafe.Pointer(res_ptr))
+	if program != "" {
+		program_cstr := C.CString(program)
+		defer C.free(unsafe.Pointer(program_cstr))
+		cfg.program = program_cstr
+	}
+	if !opts.confine && len(opts.hidden) > 0 {
+		hidden_cstr := newCArgv(opts.hidden)
+		defer freeCArgv(hidden_cstr, len(opts.hidden))
+		cfg.hidden = hidden_cstr
+		cfg.hidden_count = C.int(len(opts.hidden))
+		cfg.tmpfs_size = C.int(GetConfig().Core.TmpfsSize)
+		if cfg.tmpfs_size <= 0 {
+			cfg.tmpfs_size = defaultTmpfsSize
+		}
+		if opts.visible != "" {
+			visible_cstr := C.CString(opts.visible)
+			defer C.free(unsafe.Pointer(visible_cstr))
+			cfg.visible = visible_cstr
+		}
+	}
+	if rootfs != "" {
+		rootfs_cstr := C.CString(rootfs)
+		defer C.free(unsafe.Pointer(rootfs_cstr))
+		cfg.rootfs = rootfs_cstr
+		cfg.tmpfs_size = C.int(GetConfig().Core.TmpfsSize)
+		if cfg.tmpfs_size <= 0 {
+			cfg.tmpfs_size = defaultTmpfsSize
+		}
+	}
+
+	// memory and time are read from the cgroup when available, as rusage misses child processes
+	group := newCgroup(point, orDefault(opts.maxProcesses, GetConfig().Core.MaxProcesses))
+	if group != nil {
+		defer group.Remove()
+		procs_cstr := C.CString(group.ProcsPath())
+		defer C.free(unsafe.Pointer(procs_cstr))
+		cfg.cgroup_procs = procs_cstr
+	}
+
+	argv_cstr := newCArgv(argv)
+	defer freeCArgv(argv_cstr, len(argv))
+
+	// the child is traced from start to end within this call, which keeps its OS thread as ptrace requires;
+	// timers and process groups are per run, so concurrent calls do not interfere
+	res_ptr := C.execute(argv_cstr, cfg)
 	stdin.Close()
 	stdout.Close()
+	stderr.Close()
+	output_string, output_exceeded := output.Wait()
+	errput_string, _ := errput.Wait()
+	if res_ptr == nil {
+		return "", ExecResult{ Code: IE }
+	}
+	defer C.free(unsafe.Pointer(res_ptr))
 
-	if res_ptr.code == C.RC_OK {
-		output_bytes := make([]byte, 0)
-		for {
-			chunk := make([]byte, 1024)
-			n, _ := stdout_parent.Read(chunk)
-			if n == 0 {
-				break
-			}
-			output_bytes = append(output_bytes, chunk[:n]...)
-			if n < 1024 {
-				break
+	code := int(res_ptr.code)
+	exec_time, exec_mem := float32(res_ptr.exec_time), int(res_ptr.exec_mem)
+	wall_time := float32(res_ptr.wall_time)
+	if group != nil {
+		stats := group.Stats()
+		if code == OK || code == RE {
+			if stats.OOMKilled {
+				code = MLE
+			} else if point.CpuLimit() > 0 && stats.Usage * 1000 > float32(point.CpuLimit()) {
+				code = TLE
 			}
 		}
-		return string(output_bytes), ExecResult{
-			Code:       int(res_ptr.code),
-			ExecTime:   float32(res_ptr.exec_time),
-			ExecMemory: int(res_ptr.exec_mem),
+		exec_time = stats.Usage
+		if stats.Peak > 0 {
+			exec_mem = stats.Peak
+		}
+	}
+
+	if files.Output != "" {
+		file_exceeded := false
+		output_string, file_exceeded = readOutputFile(filepath.Join(workdir, files.Output), point.OutLimit())
+		output_exceeded = output_exceeded || file_exceeded
+	}
+
+	// the program is killed by SIGPIPE or sees EPIPE once the output is cut off
+	if output_exceeded && (code == OK || code == RE) {
+		code = OLE
+	}
+
+	if code == OK {
+		return output_string, ExecResult{
+			Code:       code,
+			ExecTime:   exec_time,
+			WallTime:   wall_time,
+			ExecMemory: exec_mem,
+			ExitCode:   int(res_ptr.exit_code),
+			Stderr:     errput_string,
+		}
+	}
+
+	if code == TLE || code == OLE {
+		return "", ExecResult{
+			Code:     code,
+			ExecTime: exec_time,
+			WallTime: wall_time,
+			Stderr:   errput_string,
+		}
+	}
+
+	if code == MLE {
+		return "", ExecResult{
+			Code:       code,
+			ExecMemory: exec_mem,
+			Stderr:     errput_string,
 		}
 	}
 
 	return "", ExecResult{
-		Code:    int(res_ptr.code),
+		Code:    code,
 		Syscall: int(res_ptr.syscall),
 		TermSig: int(res_ptr.termsig),
+		Stderr:  errput_string,
+	}
+}
+
+// Copies argv into a NULL-terminated array of C strings.
+func newCArgv(argv []string) **C.char {
+	argv_cstr := (**C.char)(C.malloc(C.size_t(len(argv) + 1) * C.size_t(unsafe.Sizeof((*C.char)(nil)))))
+	slots := unsafe.Slice(argv_cstr, len(argv) + 1)
+	for i, arg := range argv {
+		slots[i] = C.CString(arg)
+	}
+	slots[len(argv)] = nil
+	return argv_cstr
+}
+
+func freeCArgv(argv_cstr **C.char, count int) {
+	for _, arg := range unsafe.Slice(argv_cstr, count) {
+		C.free(unsafe.Pointer(arg))
+	}
+	C.free(unsafe.Pointer(argv_cstr))
+}
+
+// How long to wait for the output after the program exits.
+// Only reached if an escaped process still holds the pipe.
+const drainTimeout = time.Second
+
+// Output of a running program, read into a bounded buffer.
+type boundedOutput struct {
+	r			*os.File
+	buf			[]byte
+	exceeded	bool
+	done		chan struct{}
+}
+
+// Starts reading r until EOF. If more than limit bytes arrive, the output is marked as exceeded;
+// r is closed if strict, otherwise the excess is discarded. A non-positive limit disables the check.
+func drainOutput(r *os.File, limit int, strict bool) *boundedOutput {
+	o := &boundedOutput{ r: r, done: make(chan struct{}) }
+	go func() {
+		defer close(o.done)
+		defer r.Close()
+		chunk := make([]byte, 4096)
+		for {
+			n, err := r.Read(chunk)
+			if !o.exceeded {
+				o.buf = append(o.buf, chunk[:n]...)
+			}
+			if limit > 0 && len(o.buf) > limit {
+				o.buf = o.buf[:limit]
+				o.exceeded = true
+				if strict {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return o
+}
+
+// Lets the interactor talk to the running program through the parent ends of its pipes, which are closed once it returns.
+// The resulting output stays empty, but is marked as exceeded if the program writes more than limit bytes.
+func startInteraction(interactor Interactor, stdin *os.File, stdout *os.File, limit int) *boundedOutput {
+	o := &boundedOutput{ r: stdout, done: make(chan struct{}) }
+	go func() {
+		defer close(o.done)
+		defer stdout.Close()
+		defer stdin.Close()
+		interactor.Interact(stdin, &countingReader{ r: stdout, limit: limit, exceeded: &o.exceeded })
+	}()
+	return o
+}
+
+// Reader which stops with EOF past a limit.
+type countingReader struct {
+	r			io.Reader
+	n			int
+	limit		int
+	exceeded	*bool
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if *c.exceeded {
+		return 0, io.EOF
+	}
+	n, err := c.r.Read(p)
+	c.n += n
+	if c.limit > 0 && c.n > c.limit {
+		*c.exceeded = true
+	}
+	return n, err
+}
+
+// Waits until the output is drained. Returns the output and whether the limit was exceeded.
+func (o *boundedOutput) Wait() (string, bool) {
+	select {
+	case <-o.done:
+	case <-time.After(drainTimeout):
+		o.r.Close()
+		<-o.done
+	}
+	return string(o.buf), o.exceeded
+}
+
+// Reads the output file left by the program, at most limit bytes past which it is marked as exceeded.
+// Anything but a regular file, such as a symbolic link planted by the program, reads as empty.
+func readOutputFile(path string, limit int) (string, bool) {
+	fp, err := os.OpenFile(path, os.O_RDONLY | syscall.O_NOFOLLOW | syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return "", false
+	}
+	defer fp.Close()
+	if info, err := fp.Stat(); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	var r io.Reader = fp
+	if limit > 0 {
+		r = io.LimitReader(fp, int64(limit) + 1)
+	}
+	buf, _ := io.ReadAll(r)
+	if limit > 0 && len(buf) > limit {
+		return string(buf[:limit]), true
 	}
+	return string(buf), false
 }
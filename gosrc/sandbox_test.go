@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Under ptrace, the stop after the program's own execve is not taken for a disallowed execve.
+func TestExecutePtrace(t *testing.T) {
+	config := GetConfig()
+	saved := *config
+	defer func() { *config = saved }()
+	if _, err := os.Stat("/bin/true"); err != nil {
+		t.Skip("/bin/true is missing")
+	}
+	config.Core.RootFS = ""
+	config.Core.UsePtrace = true
+	config.Core.DisallowedSyscall = []int32{ 57, 58, 59, 322 }
+
+	point := DataPoint{ CpuTimeLimit: 1000, MemoryLimit: 64 << 20 }
+	_, result := Execute(Command{ Program: "/bin/true" }, point, FileIO{}, nil)
+	if result.Code != OK {
+		t.Fatalf("trivial program got %d (syscall %d), expected OK", result.Code, result.Syscall)
+	}
+	// a later execve is still caught
+	_, result = Execute(Command{ Argv: []string{ "/bin/sh", "-c", "exec /bin/true" } }, point, FileIO{}, nil)
+	if result.Code != SE || result.Syscall != 59 {
+		t.Fatalf("execve by the program got %d (syscall %d), expected SE 59", result.Code, result.Syscall)
+	}
+}
+
+// In file I/O mode, files outside the working directory cannot be read unless they belong to the system.
+func TestExecuteFileIOReads(t *testing.T) {
+	config := GetConfig()
+	saved := *config
+	defer func() { *config = saved }()
+	if _, err := os.Stat("/bin/cat"); err != nil {
+		t.Skip("/bin/cat is missing")
+	}
+	secret := "zdotoj-secret"
+	config.Core.RootFS = ""
+	config.Core.TemporaryFolder = t.TempDir()
+	config.Location = filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(config.Location, ([]byte)(secret), 0o644)
+
+	point := DataPoint{ In: "input", CpuTimeLimit: 1000, MemoryLimit: 64 << 20 }
+	for _, use_ptrace := range []bool{ false, true } {
+		config.Core.UsePtrace = use_ptrace
+		command := Command{ Argv: []string{ "/bin/cat", "in.txt", config.Location } }
+		output, result := Execute(command, point, FileIO{ Input: "in.txt" }, nil)
+		if result.Code != OK || result.ExitCode == 0 || output != "input" {
+			t.Errorf("reading a hidden file got %d (exit code %d) with output %q, expected a failing open", result.Code, result.ExitCode, output)
+		}
+	}
+}
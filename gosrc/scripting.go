@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"io"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/yuin/gopher-lua"
 )
 
+// Lua states are not safe for concurrent use, so each shared state has its own lock.
 var rjState *lua.LState = lua.NewState(lua.Options{ SkipOpenLibs: true })
+var rjMutex = sync.Mutex{}
 var sjState *lua.LState = lua.NewState(lua.Options{ SkipOpenLibs: true })
-var auxData lua.LValue = nil
+var sjMutex = sync.Mutex{}
 
 func init() {
 	if err := loadMinimalLibs(rjState); err != nil {
@@ -50,14 +56,6 @@ var helperFunctions = map[string] lua.LGFunction {
 		L.Push(lua.LNumber(time.Now().Unix()))
 		return 1
 	},
-	"setauxdata": func(L *lua.LState) int {
-		auxData = L.Get(1)
-		return 0
-	},
-	"getauxdata": func(L *lua.LState) int {
-		L.Push(auxData)
-		return 1
-	},
 }
 
 func addHelpFunctions(L *lua.LState) {
@@ -66,6 +64,40 @@ func addHelpFunctions(L *lua.LState) {
 	}
 }
 
+// Auxiliary data shared by the scripts of a single objective run.
+type AuxData struct {
+	mutex		sync.Mutex
+	// Keyed by data point index if each point keeps its own, otherwise stored at 0.
+	values		map[int]lua.LValue
+	perPoint	bool
+}
+
+// Creates empty auxiliary data. If perPoint, scripts of different data points do not see each other's data,
+// which is the only meaningful order when data points run concurrently.
+func NewAuxData(perPoint bool) *AuxData {
+	return &AuxData{ values: make(map[int]lua.LValue), perPoint: perPoint }
+}
+
+// Installs setauxdata and getauxdata for the data point with given index.
+func (a *AuxData) install(L *lua.LState, index int) {
+	slot := 0
+	if a.perPoint {
+		slot = index
+	}
+	L.SetGlobal("setauxdata", L.NewFunction(func(L *lua.LState) int {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		a.values[slot] = L.Get(1)
+		return 0
+	}))
+	L.SetGlobal("getauxdata", L.NewFunction(func(L *lua.LState) int {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		L.Push(a.values[slot])
+		return 1
+	}))
+}
+
 func loadMinimalLibs(L *lua.LState) error {
 	for _, pair := range []struct {
         n string
@@ -89,21 +121,28 @@ func loadMinimalLibs(L *lua.LState) error {
 }
 
 // Invoke RandomJudge script.
-func InvokeRandomJudgeScript(script string, index int) (bool, DataPoint) {
+func InvokeRandomJudgeScript(script string, index int, aux *AuxData) (bool, DataPoint) {
+	rjMutex.Lock()
+	defer rjMutex.Unlock()
 	L := rjState
+	aux.install(L, index)
 	point := DataPoint{}
+	// builders keep repeated feeds linear for large inputs
+	in, out := strings.Builder{}, strings.Builder{}
 	table_Z := L.NewTable()
 	func_feed := func (L *lua.LState) int {
-		point.In += L.ToString(1)
+		in.WriteString(L.ToString(1))
 		return 0
 	}
 	func_expect := func (L *lua.LState) int {
-		point.Out += L.ToString(1)
+		out.WriteString(L.ToString(1))
 		return 0
 	}
 	func_limit := func (L *lua.LState) int {
-		point.TimeLimit = L.ToInt(1)
+		// fractional seconds allowed
+		point.CpuTimeLimit = int(float64(L.ToNumber(1)) * 1000)
 		point.MemoryLimit = L.ToInt(2)
+		point.WallTimeLimit = int(float64(L.ToNumber(3)) * 1000)
 		return 0
 	}
 	table_Z.RawSetString("feed", L.NewFunction(func_feed))
@@ -114,12 +153,16 @@ func InvokeRandomJudgeScript(script string, index int) (bool, DataPoint) {
 	if err := L.DoString(script); err != nil {
 		return false, DataPoint{}
 	}
+	point.In, point.Out = in.String(), out.String()
 	return true, point
 }
 
 // Invoke SpecialJudge script.
-func InvokeSpecialJudgeScript(script string, got string, expected string, index int) (bool, bool) {
+func InvokeSpecialJudgeScript(script string, got string, expected string, index int, aux *AuxData) (bool, bool) {
+	sjMutex.Lock()
+	defer sjMutex.Unlock()
 	L := sjState
+	aux.install(L, index)
 	result, exit := false, false
 	table_Z := L.NewTable()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -141,7 +184,52 @@ func InvokeSpecialJudgeScript(script string, got string, expected string, index
 	return true, result
 }
 
-// Clears auxiliary data.
-func ClearAuxData() {
-	auxData = nil
-}
\ No newline at end of file
+// Invoke interactor script. stdin and stdout are those of the running program.
+// Each interaction gets a state of its own, as it lasts as long as the program runs.
+func InvokeInteractorScript(script string, point DataPoint, index int, aux *AuxData, stdin io.Writer, stdout io.Reader) (bool, bool) {
+	L := lua.NewState(lua.Options{ SkipOpenLibs: true })
+	defer L.Close()
+	if err := loadMinimalLibs(L); err != nil {
+		return false, false
+	}
+	addHelpFunctions(L)
+	L.DoString("math.randomseed(ostime())")
+	aux.install(L, index)
+	result, exit := false, false
+	reader := bufio.NewReader(stdout)
+	table_Z := L.NewTable()
+	ctx, cancel := interactionContext(point)
+	defer cancel()
+	L.SetContext(ctx)
+	func_read := func (L *lua.LState) int {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			L.Push(lua.LNil)
+		} else {
+			L.Push(lua.LString(strings.TrimRight(line, "\r\n")))
+		}
+		return 1
+	}
+	func_write := func (L *lua.LState) int {
+		// fails silently once the program has exited
+		io.WriteString(stdin, L.ToString(1) + "\n")
+		return 0
+	}
+	func_match := func (L *lua.LState) int {
+		defer cancel()
+		result = L.ToBool(1)
+		exit = true
+		return 0
+	}
+	table_Z.RawSetString("input", lua.LString(point.In))
+	table_Z.RawSetString("expected", lua.LString(point.Out))
+	table_Z.RawSetString("read", L.NewFunction(func_read))
+	table_Z.RawSetString("write", L.NewFunction(func_write))
+	table_Z.RawSetString("match", L.NewFunction(func_match))
+	table_Z.RawSetString("index", lua.LNumber(index))
+	L.SetGlobal("Z", table_Z)
+	if err := L.DoString(script); !exit && err != nil {
+		return false, false
+	}
+	return true, result
+}
@@ -64,6 +64,9 @@ var (
 	reasonUnitTemplateMismatch	= newReason("unit:templateMismatch",	"Code does not match template structure.")
 	reasonSystemInternalError	= newReason("system:internalError",		"An internal error has occurred.")
 	reasonSystemFeatureDisabled = newReason("system:featureDisabled", 	"Feature is disabled by configuration.")
+	reasonUnitLanguageRejected	= newReason("unit:languageRejected",	"Language is not accepted by the objective.")
+	reasonUnitTemplateInvalid	= newReason("unit:templateInvalid",		"Template of an objective is invalid.")
+	reasonUnitObjectiveInvalid	= newReason("unit:objectiveInvalid",	"Subtasks or reference of an objective are invalid.")
 )
 
 // An abstraction of a http server.
@@ -401,6 +404,26 @@ func NewServer() Server {
 	api_party.Post("/units", checkLogin(true), func (ctx iris.Context) {
 		unit := entireUnit{}
 		ctx.ReadJSON(&unit)
+		for i, objective := range unit.Objectives {
+			if objective_err := objective.ValidateFields(); objective_err != nil {
+				objective_err.Objective = i
+				ctx.JSON(iris.Map {
+					"ok": false,
+					"reason": reasonUnitObjectiveInvalid,
+					"data": objective_err,
+				})
+				return
+			}
+			if template_err := objective.Validate(); template_err != nil {
+				template_err.Objective = i
+				ctx.JSON(iris.Map {
+					"ok": false,
+					"reason": reasonUnitTemplateInvalid,
+					"data": template_err,
+				})
+				return
+			}
+		}
 		id := db.PutUnit(unit)
 		ctx.JSON(iris.Map {
 			"ok": true,
@@ -420,6 +443,26 @@ func NewServer() Server {
 		}
 		unit := entireUnit{}
 		ctx.ReadJSON(&unit)
+		for i, objective := range unit.Objectives {
+			if objective_err := objective.ValidateFields(); objective_err != nil {
+				objective_err.Objective = i
+				ctx.JSON(iris.Map {
+					"ok": false,
+					"reason": reasonUnitObjectiveInvalid,
+					"data": objective_err,
+				})
+				return
+			}
+			if template_err := objective.Validate(); template_err != nil {
+				template_err.Objective = i
+				ctx.JSON(iris.Map {
+					"ok": false,
+					"reason": reasonUnitTemplateInvalid,
+					"data": template_err,
+				})
+				return
+			}
+		}
 		db.UpdateUnit(id, unit)
 		ctx.JSON(iris.Map {
 			"ok": true,
@@ -458,8 +501,10 @@ func NewServer() Server {
 			return
 		}
 		body := struct {
-			Index	int			`json:"index"`
-			Code 	[]string	`json:"code"`
+			Index		int			`json:"index"`
+			Code 		[]string	`json:"code"`
+			// Language of the objective if nil.
+			Language	*uint8		`json:"language"`
 		}{}
 		if ctx.ReadJSON(&body) != nil {
 			ctx.StopWithJSON(iris.StatusBadRequest, iris.Map {
@@ -489,22 +534,30 @@ func NewServer() Server {
 				"reason": reasonUnitIndexOverflow,
 			})
 		}
-		var code string
-		obj := unit.Objectives[0]
-		if obj.Template == nil {
-			code = body.Code[0]
-		} else {
-			temp_code, ok := joinCodeTemplate(body.Code, obj.Template)
-			if !ok {
-				ctx.JSON(iris.Map {
-					"ok": false,
-					"reason": reasonUnitTemplateMismatch,
-				})
-				return
-			}
-			code = temp_code
+		lang := unit.Objectives[0].Language
+		if body.Language != nil {
+			lang = *body.Language
+		}
+		obj, ok := unit.Objectives[0].In(lang)
+		// listed languages may have lost their toolchain since
+		if _, installed := compilerMap[lang]; !ok || !installed {
+			ctx.JSON(iris.Map {
+				"ok": false,
+				"reason": reasonUnitLanguageRejected,
+			})
+			return
+		}
+		code, source_map, template_err := joinCodeTemplate(body.Code, obj.Template)
+		if template_err != nil {
+			template_err.Objective, template_err.Language = body.Index, obj.Language
+			ctx.JSON(iris.Map {
+				"ok": false,
+				"reason": reasonUnitTemplateMismatch,
+				"data": template_err,
+			})
+			return
 		}
-		task := NewTask(obj, code)
+		task := NewTask(obj, code, source_map)
 		wait := make(chan int)
 		task.Watch(func(_ *Task, i int) {
 			wait <- i
@@ -524,10 +577,10 @@ func NewServer() Server {
 			ctx.WriteString("\n")
 			flusher.Flush()
 			if pos < 0 {
-				if task.Result[0].Code != CE {
+				if result_code := task.Result[0].Code; result_code != CE && result_code != CTLE {
 					passed := 0
 					for _, r := range task.Result {
-						if r.Code == OK {
+						if obj.Passes(r) {
 							passed++
 						}
 					}
@@ -537,8 +590,10 @@ func NewServer() Server {
 						body.Index,
 						RecordEntry{
 							body.Code,
+							obj.Language,
 							passed,
 							unit.Objectives[0].PointCount,
+							obj.Score(task.Result),
 						},
 					)
 				}
@@ -563,8 +618,10 @@ func NewServer() Server {
 			return
 		}
 		body := struct {
-			Index	int			`json:"index"`
-			Code 	[]string	`json:"code"`
+			Index		int			`json:"index"`
+			Code 		[]string	`json:"code"`
+			// Language of the objective if nil.
+			Language	*uint8		`json:"language"`
 		}{}
 		if ctx.ReadJSON(&body) != nil {
 			ctx.StopWithJSON(iris.StatusBadRequest, iris.Map {
@@ -594,22 +651,30 @@ func NewServer() Server {
 				"reason": reasonUnitIndexOverflow,
 			})
 		}
-		var code string
-		obj := unit.Objectives[0]
-		if obj.Template == nil {
-			code = body.Code[0]
-		} else {
-			temp_code, ok := joinCodeTemplate(body.Code, obj.Template)
-			if !ok {
-				ctx.JSON(iris.Map {
-					"ok": false,
-					"reason": reasonUnitTemplateMismatch,
-				})
-				return
-			}
-			code = temp_code
+		lang := unit.Objectives[0].Language
+		if body.Language != nil {
+			lang = *body.Language
 		}
-		task := NewTask(obj, code)
+		obj, ok := unit.Objectives[0].In(lang)
+		// listed languages may have lost their toolchain since
+		if _, installed := compilerMap[lang]; !ok || !installed {
+			ctx.JSON(iris.Map {
+				"ok": false,
+				"reason": reasonUnitLanguageRejected,
+			})
+			return
+		}
+		code, source_map, template_err := joinCodeTemplate(body.Code, obj.Template)
+		if template_err != nil {
+			template_err.Objective, template_err.Language = body.Index, obj.Language
+			ctx.JSON(iris.Map {
+				"ok": false,
+				"reason": reasonUnitTemplateMismatch,
+				"data": template_err,
+			})
+			return
+		}
+		task := NewTask(obj, code, source_map)
 		wait := make(chan int)
 		task.Watch(func(_ *Task, i int) {
 			wait <- i
@@ -617,10 +682,10 @@ func NewServer() Server {
 		go queue.Push(task)
 		for pos := range wait {
 			if pos < 0 {
-				if task.Result[0].Code != CE {
+				if result_code := task.Result[0].Code; result_code != CE && result_code != CTLE {
 					passed := 0
 					for _, r := range task.Result {
-						if r.Code == OK {
+						if obj.Passes(r) {
 							passed++
 						}
 					}
@@ -630,8 +695,10 @@ func NewServer() Server {
 						body.Index,
 						RecordEntry{
 							body.Code,
+							obj.Language,
 							passed,
 							unit.Objectives[0].PointCount,
+							obj.Score(task.Result),
 						},
 					)
 				}
@@ -657,8 +724,10 @@ func NewServer() Server {
 			return
 		}
 		body := struct {
-			Index	int			`json:"index"`
-			Code 	[]string	`json:"code"`
+			Index		int			`json:"index"`
+			Code 		[]string	`json:"code"`
+			// Language of the objective if nil.
+			Language	*uint8		`json:"language"`
 		}{}
 		if ctx.ReadJSON(&body) != nil {
 			ctx.StopWithJSON(iris.StatusBadRequest, iris.Map {
@@ -682,22 +751,30 @@ func NewServer() Server {
 				"reason": reasonUnitIndexOverflow,
 			})
 		}
-		var code string
-		obj := unit.Objectives[0]
-		if obj.Template == nil {
-			code = body.Code[0]
-		} else {
-			temp_code, ok := joinCodeTemplate(body.Code, obj.Template)
-			if !ok {
-				ctx.JSON(iris.Map {
-					"ok": false,
-					"reason": reasonUnitTemplateMismatch,
-				})
-				return
-			}
-			code = temp_code
+		lang := unit.Objectives[0].Language
+		if body.Language != nil {
+			lang = *body.Language
+		}
+		obj, ok := unit.Objectives[0].In(lang)
+		// listed languages may have lost their toolchain since
+		if _, installed := compilerMap[lang]; !ok || !installed {
+			ctx.JSON(iris.Map {
+				"ok": false,
+				"reason": reasonUnitLanguageRejected,
+			})
+			return
+		}
+		code, source_map, template_err := joinCodeTemplate(body.Code, obj.Template)
+		if template_err != nil {
+			template_err.Objective, template_err.Language = body.Index, obj.Language
+			ctx.JSON(iris.Map {
+				"ok": false,
+				"reason": reasonUnitTemplateMismatch,
+				"data": template_err,
+			})
+			return
 		}
-		task := NewTask(obj, code)
+		task := NewTask(obj, code, source_map)
 		wait := make(chan int)
 		task.Watch(func(_ *Task, i int) {
 			wait <- i
@@ -781,6 +858,13 @@ func NewServer() Server {
 		})
 	})
 
+	api_party.Get("/languages", checkLogin(false), func (ctx iris.Context) {
+		ctx.JSON(iris.Map {
+			"ok": true,
+			"data": ListLanguages(),
+		})
+	})
+
 	api_party.Get("/stat", func (ctx iris.Context) {
 		stat := db.Stats()
 		ctx.JSON(iris.Map {
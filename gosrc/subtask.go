@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+)
+
+const (
+	// Full score only if every point passes. Default.
+	ScoringAll	= "all"
+	// Score scaled by the lowest share earned by its points, which is below 1 only with partial results.
+	ScoringMin	= "min"
+)
+
+// A group of data points scored together.
+type Subtask struct {
+	// Score of the subtask.
+	Score	float64	`json:"score"`
+	// Indices of data points, in ascending order. A point may belong to several subtasks.
+	Points	[]int	`json:"points"`
+	// Scoring method, ScoringAll if empty.
+	Scoring	string	`json:"scoring"`
+}
+
+// Whether a data point passed. Presentation errors pass if AcceptPE is set.
+func (o *Objective) Passes(r Result) bool {
+	return r.Code == OK || (r.Code == PE && o.AcceptPE)
+}
+
+// Share of its score earned by a data point. Partial results carry their own share.
+func (o *Objective) share(r Result) float64 {
+	if o.Passes(r) {
+		return 1
+	}
+	switch r.Code {
+	case PC:
+		if data, ok := r.Data.(PCResult); ok {
+			return data.Score
+		}
+	}
+	return 0
+}
+
+// Score of given results of this objective. Without subtasks, 100 is shared equally among all points.
+func (o *Objective) Score(results []Result) float64 {
+	// compile errors come as a single result
+	if len(results) != o.PointCount || o.PointCount <= 0 {
+		return 0
+	}
+	if len(o.Subtasks) == 0 {
+		sum := 0.0
+		for _, result := range results {
+			sum += o.share(result)
+		}
+		return sum * 100 / float64(o.PointCount)
+	}
+	score := 0.0
+	for _, subtask := range o.Subtasks {
+		// subtasks without valid points earn nothing, see Objective.Validate
+		share, valid := 1.0, false
+		for _, i := range subtask.Points {
+			if i < 0 || i >= len(results) {
+				continue
+			}
+			point_share := o.share(results[i])
+			if subtask.Scoring != ScoringMin && point_share < 1 {
+				point_share = 0
+			}
+			share, valid = min(share, point_share), true
+		}
+		if valid {
+			score += subtask.Score * share
+		}
+	}
+	return score
+}
+
+// Tracks failed subtasks, whose remaining points are skipped.
+type subtaskSkipper struct {
+	subtasks	[]Subtask
+	// Subtasks containing each point.
+	owners		map[int][]int
+	failed		[]bool
+	mutex		sync.Mutex
+}
+
+func newSubtaskSkipper(subtasks []Subtask) *subtaskSkipper {
+	owners := make(map[int][]int)
+	for t, subtask := range subtasks {
+		for _, i := range subtask.Points {
+			owners[i] = append(owners[i], t)
+		}
+	}
+	return &subtaskSkipper{ subtasks: subtasks, owners: owners, failed: make([]bool, len(subtasks)) }
+}
+
+// Whether every subtask containing the point has failed. Points without subtasks are never skipped.
+func (s *subtaskSkipper) skips(point int) bool {
+	owners, ok := s.owners[point]
+	if !ok {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, t := range owners {
+		if !s.failed[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// Marks the subtasks containing the point as failed if the share earned leaves them no score.
+func (s *subtaskSkipper) record(point int, share float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, t := range s.owners[point] {
+		if share == 0 || (share < 1 && s.subtasks[t].Scoring != ScoringMin) {
+			s.failed[t] = true
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	ok, wa := Result{ Code: OK }, Result{ Code: WA }
+	pe := Result{ Code: PE }
+	partial := func(share float64) Result {
+		return Result{ Code: PC, Data: PCResult{ Score: share } }
+	}
+	overlapping := []Subtask{
+		{ Score: 30, Points: []int{ 0, 1 } },
+		{ Score: 70, Points: []int{ 1, 2 }, Scoring: ScoringMin },
+	}
+	cases := []struct {
+		name		string
+		objective	Objective
+		results		[]Result
+		want		float64
+	}{
+		{ "split", Objective{ PointCount: 4 }, []Result{ ok, wa, ok, ok }, 75 },
+		{ "split into thirds", Objective{ PointCount: 3 }, []Result{ ok, ok, ok }, 100 },
+		{ "one third", Objective{ PointCount: 3 }, []Result{ ok, wa, wa }, 100.0 / 3 },
+		{ "partial split", Objective{ PointCount: 2 }, []Result{ partial(0.5), ok }, 75 },
+		{ "presentation", Objective{ PointCount: 2 }, []Result{ pe, ok }, 50 },
+		{ "presentation accepted", Objective{ PointCount: 2, AcceptPE: true }, []Result{ pe, ok }, 100 },
+		{ "compile error", Objective{ PointCount: 3 }, []Result{ { Code: CE } }, 0 },
+		{ "overlapping", Objective{ PointCount: 3, Subtasks: overlapping }, []Result{ ok, ok, ok }, 100 },
+		{ "shared point fails both", Objective{ PointCount: 3, Subtasks: overlapping }, []Result{ ok, wa, ok }, 0 },
+		{ "first subtask only", Objective{ PointCount: 3, Subtasks: overlapping }, []Result{ ok, ok, wa }, 30 },
+		// partial results keep a share of min subtasks only
+		{ "partial in both", Objective{ PointCount: 3, Subtasks: overlapping }, []Result{ ok, partial(0.5), ok }, 35 },
+		{ "lowest share", Objective{ PointCount: 3, Subtasks: overlapping }, []Result{ wa, partial(0.9), partial(0.3) }, 21 },
+		{ "skipped", Objective{ PointCount: 3, Subtasks: overlapping }, []Result{ wa, ok, { Code: SK } }, 0 },
+		{ "empty subtask", Objective{ PointCount: 2, Subtasks: []Subtask{ { Score: 50 }, { Score: 50, Points: []int{ 0, 1 } } } }, []Result{ ok, ok }, 50 },
+	}
+	for _, c := range cases {
+		if got := c.objective.Score(c.results); math.Abs(got - c.want) > 1e-9 {
+			t.Errorf("%s: score = %g, expected %g", c.name, got, c.want)
+		}
+	}
+}
+
+// Points are skipped once every subtask containing them has lost its score.
+func TestSubtaskSkipper(t *testing.T) {
+	subtasks := []Subtask{
+		{ Score: 30, Points: []int{ 0, 1 } },
+		{ Score: 40, Points: []int{ 1, 2, 3 }, Scoring: ScoringMin },
+		{ Score: 30, Points: []int{ 3, 4 } },
+	}
+	cases := []struct {
+		name	string
+		// shares recorded in order
+		shares	map[int]float64
+		order	[]int
+		skipped	[]int
+	}{
+		{ "nothing failed", map[int]float64{ 0: 1 }, []int{ 0 }, []int{} },
+		{ "failing a subtask", map[int]float64{ 0: 0 }, []int{ 0 }, []int{} },
+		// point 1 still counts for the second subtask
+		{ "shared point", map[int]float64{ 0: 0, 2: 0 }, []int{ 0, 2 }, []int{ 1 } },
+		// a partial share fails "all" subtasks but not "min" ones
+		{ "partial share", map[int]float64{ 1: 0.5 }, []int{ 1 }, []int{ 0 } },
+		{ "every owner failed", map[int]float64{ 0: 0, 2: 0, 4: 0 }, []int{ 0, 2, 4 }, []int{ 1, 3 } },
+	}
+	for _, c := range cases {
+		skipper := newSubtaskSkipper(subtasks)
+		for _, point := range c.order {
+			skipper.record(point, c.shares[point])
+		}
+		skipped := []int{}
+		for point := 0; point < 5; point++ {
+			if _, recorded := c.shares[point]; !recorded && skipper.skips(point) {
+				skipped = append(skipped, point)
+			}
+		}
+		if !slices.Equal(skipped, c.skipped) {
+			t.Errorf("%s: skipped %v, expected %v", c.name, skipped, c.skipped)
+		}
+	}
+	// points outside every subtask are never skipped
+	skipper := newSubtaskSkipper(subtasks)
+	skipper.record(0, 0)
+	if skipper.skips(5) {
+		t.Errorf("point outside every subtask is skipped")
+	}
+}
@@ -13,6 +13,7 @@ type Task struct {
 	Id			uint64
 	Objective	Objective
 	Code		string
+	SourceMap	SourceMap
 	Watcher		Watcher
 	Result		[]Result
 }
@@ -20,11 +21,12 @@ type Task struct {
 var taskIdCounter = atomic.Uint64{}
 
 // Creates a task with default values.
-func NewTask(objective Objective, code string) *Task {
+func NewTask(objective Objective, code string, source_map SourceMap) *Task {
 	return &Task {
 		taskIdCounter.Add(1),
 		objective,
 		code,
+		source_map,
 		nil,
 		nil,
 	}
@@ -47,6 +49,14 @@ func (t *Task) updatePos(pos int) {
 // Runs this task synchronously.
 func (t *Task) Run() {
 	t.Result = t.Objective.Run(t.Code)
+	// compiler messages refer to lines of joined code
+	if len(t.Result) == 1 {
+		if compile_result, ok := t.Result[0].Data.(CompileResult); ok {
+			compile_result.SourceMap = t.SourceMap
+			compile_result.hideLines(t.Objective.shownLines(t.SourceMap))
+			t.Result[0].Data = compile_result
+		}
+	}
 	t.updatePos(-1)
 }
 
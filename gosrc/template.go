@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Deepest indentation of a region, in levels of INDENT spaces.
+const MaxIndent = 16
+
+// Structural error of a template.
+type TemplateError struct {
+	// Index of the objective within its unit.
+	Objective	int				`json:"objective"`
+	// Language of the template.
+	Language	uint8			`json:"language"`
+	// Index of the offending region, or -1 if the template as a whole is at fault.
+	Region		int				`json:"region"`
+	// Description of the error.
+	Message		string			`json:"message"`
+	// Result of compiling the template with placeholder bodies, if it failed.
+	Compile		*CompileResult	`json:"compile,omitempty"`
+}
+
+func (e *TemplateError) Error() string {
+	if e.Region < 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("region %d: %s", e.Region, e.Message)
+}
+
+// Origin of a line of joined code.
+type SourceLine struct {
+	// Index of the region.
+	Region	int		`json:"region"`
+	// Line within the region, starting from 1.
+	Line	int		`json:"line"`
+}
+
+// Origins of the lines of joined code, the first element being that of line 1.
+type SourceMap []SourceLine
+
+// Origin of given line of joined code, starting from 1.
+func (m SourceMap) Lookup(line int) (SourceLine, bool) {
+	if line < 1 || line > len(m) {
+		return SourceLine{}, false
+	}
+	return m[line - 1], true
+}
+
+// Line of joined code referred to by a compiler message, as located by the default UI.
+var compileLocation = regexp.MustCompile(`(?:\S:|\bline )(\d+)`)
+
+// Compiler message quoting code, such as "   12 |   int x;" of gcc.
+var compileQuote = regexp.MustCompile(`^\s*(\d+\s*)?\|`)
+
+// Number of leading lines of joined code shown to users, which is all but those of the driver of a harness.
+func (o Objective) shownLines(source_map SourceMap) int {
+	if o.Harness == nil || o.Harness.Driver == "" {
+		return len(source_map)
+	}
+	// the driver is the last region of the template of a harness
+	driver := len(o.Template) - 1
+	for i, origin := range source_map {
+		if origin.Region == driver {
+			return i
+		}
+	}
+	return len(source_map)
+}
+
+// Hides lines of joined code past the first shown ones from compiler messages and the source map.
+// A message referring to such a line is dropped along with the messages following it up to the next located one,
+// and with a heading right before it, such as "In function 'main':". A note is left if anything is dropped.
+func (r *CompileResult) hideLines(shown int) {
+	if shown >= len(r.SourceMap) {
+		return
+	}
+	kept := []string{}
+	hiding, hidden, heading := false, false, ""
+	keep := func(line string) {
+		if !hiding {
+			kept = append(kept, line)
+		}
+		hidden = hidden || hiding
+	}
+	for _, line := range strings.Split(r.Error, "\n") {
+		match := compileLocation.FindStringSubmatch(line)
+		if compileQuote.MatchString(line) || match == nil {
+			if heading != "" {
+				keep(heading)
+				heading = ""
+			}
+			if match == nil && strings.HasSuffix(line, ":") && strings.TrimLeft(line, " \t") == line {
+				heading = line
+			} else {
+				keep(line)
+			}
+			continue
+		}
+		number, _ := strconv.Atoi(match[1])
+		hiding = number > shown && number <= len(r.SourceMap)
+		if heading != "" {
+			keep(heading)
+			heading = ""
+		}
+		keep(line)
+	}
+	if heading != "" {
+		keep(heading)
+	}
+	if hidden {
+		kept = append(kept, "messages on hidden code left out")
+	}
+	r.Error = strings.Join(kept, "\n")
+	r.SourceMap = r.SourceMap[:shown]
+}
+
+// Checks the structure of a template. A nil template leaves input unrestricted and is always valid.
+func validateTemplate(template []Region) *TemplateError {
+	if template == nil {
+		return nil
+	}
+	editable := 0
+	for i, region := range template {
+		if region.Indent < 0 || region.Indent > MaxIndent {
+			return &TemplateError{ Region: i, Message: fmt.Sprintf("indent %d out of range [0, %d]", region.Indent, MaxIndent) }
+		}
+		if region.Editable {
+			editable++
+		}
+	}
+	if editable == 0 {
+		return &TemplateError{ Region: -1, Message: "no editable region" }
+	}
+	return nil
+}
+
+// Error in a part of an objective other than its templates.
+type ObjectiveError struct {
+	// Index of the objective within its unit.
+	Objective	int		`json:"objective"`
+	// Field at fault, such as "subtasks" or "reference".
+	Field		string	`json:"field"`
+	// Description of the error.
+	Message		string	`json:"message"`
+}
+
+func (e *ObjectiveError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Validates the subtasks and the reference of an objective. The objective index of the error is left to the caller.
+func (o Objective) ValidateFields() *ObjectiveError {
+	for t, subtask := range o.Subtasks {
+		if len(subtask.Points) == 0 {
+			return &ObjectiveError{ Field: "subtasks", Message: fmt.Sprintf("subtask %d has no data points", t) }
+		}
+		for _, i := range subtask.Points {
+			if i < 0 || i >= o.PointCount {
+				return &ObjectiveError{ Field: "subtasks", Message: fmt.Sprintf("subtask %d: data point %d out of range [0, %d)", t, i, o.PointCount) }
+			}
+		}
+	}
+	if o.Reference != nil {
+		if _, ok := compilerMap[o.Reference.Language]; !ok {
+			return &ObjectiveError{ Field: "reference", Message: "language not available" }
+		}
+	}
+	return nil
+}
+
+// Validates the template of every accepted language, compiling each with the initial content of editable regions,
+// or the placeholder of the language for empty ones. The objective index of the error is left to the caller.
+func (o Objective) Validate() *TemplateError {
+	languages := []uint8{ o.Language }
+	for _, option := range o.Languages {
+		languages = append(languages, option.Language)
+	}
+	for _, lang := range languages {
+		variant, _ := o.In(lang)
+		if _, ok := compilerMap[lang]; !ok {
+			return &TemplateError{ Language: lang, Region: -1, Message: "language not available" }
+		}
+		if variant.Harness != nil && strings.TrimSpace(variant.Harness.Signature) == "" {
+			return &TemplateError{ Language: lang, Region: 0, Message: "empty function signature" }
+		}
+		if err := validateTemplate(variant.Template); err != nil {
+			err.Language = lang
+			return err
+		}
+		if variant.Template == nil {
+			continue
+		}
+		language, _ := LookupLanguage(lang)
+		code := []string{}
+		for _, region := range variant.Template {
+			if !region.Editable {
+				continue
+			}
+			if strings.TrimSpace(region.Content) == "" {
+				code = append(code, language.Placeholder)
+			} else {
+				code = append(code, region.Content)
+			}
+		}
+		joined, source_map, _ := joinCodeTemplate(code, variant.Template)
+		path, result := Compile(joined, lang, variant.Flags)
+		if !result.Ok {
+			result.SourceMap = source_map
+			return &TemplateError{ Language: lang, Region: -1, Message: "template does not compile", Compile: &result }
+		}
+		os.RemoveAll(path)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+// Compiler messages on the driver of a harness are hidden, along with its lines in the source map.
+func TestHideLines(t *testing.T) {
+	// lines 1-3 are shown, 4-5 belong to the driver
+	source_map := SourceMap{ { 0, 1 }, { 1, 1 }, { 2, 1 }, { 3, 1 }, { 3, 2 } }
+	cases := []struct {
+		name	string
+		error	string
+		want	string
+	}{
+		{
+			"shown only",
+			"src: In function 'add':\nsrc:2:5: error: 'c' undeclared\n    2 |     c;\n      |     ^",
+			"src: In function 'add':\nsrc:2:5: error: 'c' undeclared\n    2 |     c;\n      |     ^",
+		},
+		{
+			"driver only",
+			"src: In function 'main':\nsrc:5:1: error: expected ';' before '}' token\n    5 | secret()\n      |         ^",
+			"messages on hidden code left out",
+		},
+		{
+			"both",
+			"src:2:5: error: 'c' undeclared\nsrc: In function 'main':\nsrc:4:3: error: 'x' undeclared\n    4 |   x = a?1:2;\nsrc:3:1: note: here",
+			"src:2:5: error: 'c' undeclared\nsrc:3:1: note: here\nmessages on hidden code left out",
+		},
+		{
+			"python",
+			"  File \"src.py\", line 4\n    secret(\n          ^\nSyntaxError: '(' was never closed",
+			"messages on hidden code left out",
+		},
+		{
+			"other files",
+			"/usr/include/stdio.h:356:12: note: declared here",
+			"/usr/include/stdio.h:356:12: note: declared here",
+		},
+	}
+	for _, c := range cases {
+		result := CompileResult{ Error: c.error, SourceMap: source_map }
+		result.hideLines(3)
+		if result.Error != c.want {
+			t.Errorf("%s: got %q, expected %q", c.name, result.Error, c.want)
+		}
+		if len(result.SourceMap) != 3 {
+			t.Errorf("%s: source map of %d lines, expected 3", c.name, len(result.SourceMap))
+		}
+	}
+}
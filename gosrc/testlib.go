@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits of native checkers and validators, if not configured.
+const (
+	defaultCheckerTimeLimit		= 10000
+	defaultCheckerMemoryLimit	= 512 << 20
+)
+
+// Exit codes of testlib.
+const (
+	testlibOk				= 0
+	testlibWrongAnswer		= 1
+	testlibPresentation		= 2
+	testlibFail				= 3
+	testlibDirt				= 4
+	testlibPoints			= 7
+	testlibUnexpectedEOF	= 8
+	// Base of "partially correct" codes, if testlib is built with PC_BASE_EXIT_CODE 50.
+	testlibPartially		= 50
+)
+
+// Compiles a testlib checker or validator in C++, with Core.TestlibPath added to the include path if set.
+func compileTestlib(code string) (string, CompileResult) {
+	core := GetConfig().Core
+	var flags []string
+	if core.TestlibPath != "" {
+		flags = append(slices.Clone(core.CompilerFlags["cpp"]), "-I" + core.TestlibPath)
+	}
+	return Compile(code, LanguageCpp, flags)
+}
+
+// Runs a trusted testlib program in sandbox with Core.CheckerTimeLimit and Core.CheckerMemoryLimit.
+func runTestlib(command Command, stdin string) ExecResult {
+	core := GetConfig().Core
+	limits := DataPoint{
+		In:				stdin,
+		CpuTimeLimit:	orDefault(core.CheckerTimeLimit, defaultCheckerTimeLimit),
+		MemoryLimit:	orDefault(core.CheckerMemoryLimit, defaultCheckerMemoryLimit),
+	}
+	_, execution_result := execute(command, limits, FileIO{}, nil, execOptions{
+		stderrLimit:	core.StderrLimit,
+	})
+	return execution_result
+}
+
+// Verdict of a testlib checker.
+type checkerVerdict struct {
+	// Result code, either OK, WA, PE or PC.
+	Code	int
+	// Share of the score of the data point earned, within [0, 1].
+	Share	float64
+	// Message written by the checker.
+	Message	string
+}
+
+// Checks output of a data point with a native checker, invoked as "checker <input> <output> <answer>" with the files
+// holding DataPoint.In, the output and DataPoint.Out. Returns false if the checker fails or cannot be run.
+func checkTestlib(path string, point DataPoint, output string) (checkerVerdict, bool) {
+	folder := GetConfig().Core.TemporaryFolder
+	files := []string{ RandomFile(folder), RandomFile(folder), RandomFile(folder) }
+	for i, content := range []string{ point.In, output, point.Out } {
+		defer os.Remove(files[i])
+		if os.WriteFile(files[i], ([]byte)(content), 0o644) != nil {
+			return checkerVerdict{}, false
+		}
+	}
+	execution_result := runTestlib(Command{ Program: path, Argv: append([]string{ "{exe}" }, files...) }, "")
+	if execution_result.Code != OK {
+		return checkerVerdict{}, false
+	}
+	return testlibVerdict(execution_result.ExitCode, strings.TrimSpace(execution_result.Stderr))
+}
+
+// Maps the exit code of a testlib checker to its verdict. Points given by quitp are read from the message,
+// which then starts with "points", and are taken as the share of the data point.
+func testlibVerdict(exit_code int, message string) (checkerVerdict, bool) {
+	switch {
+	case exit_code == testlibOk:
+		return checkerVerdict{ OK, 1, message }, true
+	case exit_code == testlibWrongAnswer:
+		return checkerVerdict{ WA, 0, message }, true
+	case exit_code == testlibPresentation || exit_code == testlibDirt || exit_code == testlibUnexpectedEOF:
+		return checkerVerdict{ PE, 0, message }, true
+	case exit_code == testlibPoints:
+		fields := strings.Fields(message)
+		if len(fields) < 2 || fields[0] != "points" {
+			return checkerVerdict{}, false
+		}
+		share, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return checkerVerdict{}, false
+		}
+		return partialVerdict(share, message), true
+	case exit_code >= testlibPartially && exit_code <= testlibPartially + 100:
+		return partialVerdict(float64(exit_code - testlibPartially) / 100, message), true
+	}
+	return checkerVerdict{}, false
+}
+
+// Verdict of a share, which is clamped to [0, 1]. Full and zero shares are OK and WA.
+func partialVerdict(share float64, message string) checkerVerdict {
+	share = min(max(share, 0), 1)
+	switch share {
+	case 1:
+		return checkerVerdict{ OK, 1, message }
+	case 0:
+		return checkerVerdict{ WA, 0, message }
+	}
+	return checkerVerdict{ PC, share, message }
+}
+
+// Checks input of a data point with a native validator, which reads DataPoint.In from standard input and exits with
+// 0 if it is valid.
+func validateTestlib(path string, point DataPoint) bool {
+	execution_result := runTestlib(Command{ Program: path }, point.In)
+	return execution_result.Code == OK && execution_result.ExitCode == 0
+}
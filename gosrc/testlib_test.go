@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTestlibVerdict(t *testing.T) {
+	cases := []struct {
+		exitCode	int
+		message		string
+		ok			bool
+		code		int
+		share		float64
+	}{
+		{ testlibOk, "ok 3 numbers", true, OK, 1 },
+		{ testlibWrongAnswer, "wrong answer 1st numbers differ", true, WA, 0 },
+		{ testlibPresentation, "wrong output format", true, PE, 0 },
+		// the checker itself failed
+		{ testlibFail, "FAIL bad answer file", false, 0, 0 },
+		{ testlibDirt, "extra data", true, PE, 0 },
+		{ testlibPoints, "points 0.25 quarter", true, PC, 0.25 },
+		{ testlibPoints, "points 1", true, OK, 1 },
+		{ testlibPoints, "points 0", true, WA, 0 },
+		{ testlibPoints, "points 1.5", true, OK, 1 },
+		{ testlibPoints, "points -1", true, WA, 0 },
+		{ testlibPoints, "points", false, 0, 0 },
+		{ testlibPoints, "ok 0.5", false, 0, 0 },
+		{ testlibPoints, "points half", false, 0, 0 },
+		{ testlibUnexpectedEOF, "unexpected eof", true, PE, 0 },
+		{ testlibPartially, "partially correct", true, WA, 0 },
+		{ testlibPartially + 33, "partially correct", true, PC, 0.33 },
+		{ testlibPartially + 100, "partially correct", true, OK, 1 },
+		{ testlibPartially + 101, "", false, 0, 0 },
+		{ 5, "", false, 0, 0 },
+		{ 200, "", false, 0, 0 },
+	}
+	for _, c := range cases {
+		verdict, ok := testlibVerdict(c.exitCode, c.message)
+		if ok != c.ok {
+			t.Errorf("exit code %d with %q: ok = %v, expected %v", c.exitCode, c.message, ok, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if verdict.Code != c.code || verdict.Share != c.share || verdict.Message != c.message {
+			t.Errorf("exit code %d with %q = %+v, expected code %d and share %g", c.exitCode, c.message, verdict, c.code, c.share)
+		}
+	}
+}
+
+// Partial verdicts earn their share of the score of a data point.
+func TestTestlibShare(t *testing.T) {
+	o := Objective{ PointCount: 2 }
+	results := []Result{}
+	for _, exit_code := range []int{ testlibPartially + 40, testlibOk } {
+		verdict, _ := testlibVerdict(exit_code, "")
+		result := Result{ Code: verdict.Code }
+		if verdict.Code == PC {
+			result.Data = PCResult{ Score: verdict.Share }
+		}
+		results = append(results, result)
+	}
+	if score := o.Score(results); score != 70 {
+		t.Errorf("score = %g, expected 70", score)
+	}
+}
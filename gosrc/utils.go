@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
 	"reflect"
 	"strings"
@@ -49,28 +50,51 @@ func toBsonMap(v any, withoutId bool) bson.M {
 	return bson_map
 }
 
-func joinCodeTemplate(code []string, template []Region) (string, bool) {
+// Joins user code into the editable regions of a template, indenting every line of each region by its Indent.
+// A nil template takes a single piece of code as is. Also returns where each joined line comes from.
+func joinCodeTemplate(code []string, template []Region) (string, SourceMap, *TemplateError) {
+	if template == nil {
+		if len(code) != 1 {
+			return "", nil, &TemplateError{ Region: -1, Message: fmt.Sprintf("expected 1 piece of code, got %d", len(code)) }
+		}
+		source_map := SourceMap{}
+		for i := range strings.Split(code[0], "\n") {
+			source_map = append(source_map, SourceLine{ 0, i + 1 })
+		}
+		return code[0], source_map, nil
+	}
 	count := 0
-	for _, region := range template {
-		if region.Editable { count++ }
+	for i, region := range template {
+		if !region.Editable { continue }
+		if count >= len(code) {
+			return "", nil, &TemplateError{ Region: i, Message: "missing code of editable region" }
+		}
+		count++
+	}
+	if count != len(code) {
+		return "", nil, &TemplateError{ Region: -1, Message: fmt.Sprintf("expected %d pieces of code, got %d", count, len(code)) }
 	}
-	if count != len(code) { return "", false }
 	buf := strings.Builder{}
+	source_map := SourceMap{}
 	index := 0
-	for _, region := range template {
-		for i := 0; i < region.Indent * INDENT; i++ {
-			buf.WriteString(" ");
-		}
-		if region.Editable { 
-			buf.WriteString(code[index])
-			buf.WriteByte('\n')
+	for r, region := range template {
+		content := region.Content
+		if region.Editable {
+			content = code[index]
 			index++
-		} else {
-			buf.WriteString(region.Content)
+		}
+		indent := strings.Repeat(" ", region.Indent * INDENT)
+		for i, line := range strings.Split(content, "\n") {
+			// blank lines are left without trailing spaces
+			if strings.TrimSpace(line) != "" {
+				buf.WriteString(indent)
+			}
+			buf.WriteString(line)
 			buf.WriteByte('\n')
+			source_map = append(source_map, SourceLine{ r, i + 1 })
 		}
 	}
-	return buf.String(), true
+	return buf.String(), source_map, nil
 }
 
 type uint16Flags struct {
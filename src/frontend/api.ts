@@ -19,7 +19,30 @@ export interface Region {
 }
 
 export enum Mode {
-    Strict = 1, Special = 2, Random = 4
+    Strict = 1, Special = 2, Random = 4, ExitCheck = 8, Interactive = 16
+}
+
+// Driver is never sent to users.
+export interface Harness {
+    header: string
+    signature: string
+    footer: string
+    driver?: string
+}
+
+export interface Subtask {
+    score: number
+    // indices of data points
+    points: number[]
+    scoring?: 'all' | 'min'
+}
+
+export interface ObjectiveLanguage {
+    language: number
+    template: Region[] | null
+    harness?: Harness | null
+    flags?: string[]
+    timeMultiplier?: number
 }
 
 export interface ObjectiveInfo {
@@ -27,22 +50,47 @@ export interface ObjectiveInfo {
     description: string
     difficulty: number
     template: Region[]
+    harness?: Harness | null
     mode: number
+    checker?: string
+    epsilon?: number
+    acceptPE?: boolean
     language: number
+    flags?: string[]
+    timeMultiplier?: number
+    languages?: ObjectiveLanguage[]
     pointCount: number
+    subtasks?: Subtask[] | null
+    showStderr?: boolean
+    inputFile?: string
+    outputFile?: string
 }
 
 export interface DataPoint {
     in: string
     out: string
     timeLimit: number
+    cpuTimeLimit?: number
+    wallTimeLimit?: number
     memoryLimit: number
+    outputLimit?: number
 }
 
 export interface Objective extends ObjectiveInfo {
     points: DataPoint[] | null
     rScript: string
     sScript: string
+    iScript?: string
+    interactor?: string
+    nativeChecker?: string
+    validator?: string
+    reference?: Solution | null
+}
+
+export interface Solution {
+    code: string
+    language: number
+    flags?: string[]
 }
 
 export interface Unit<T> {
@@ -73,14 +121,19 @@ export type Passwords = {
 }
 
 export enum Status {
-    IE = -3,
+    PC = -7,
+    PE,
+    SK,
+    CTLE,
+    IE,
     CE,
     WA,
     OK,
     RE,
     TLE,
     MLE,
-    SE
+    SE,
+    OLE
 }
 
 export type Result = {
@@ -88,15 +141,23 @@ export type Result = {
     data: string
 } |
 {
-    code: Status.CE,
+    code: Status.SK,
+    data: null
+} |
+{
+    code: Status.CE | Status.CTLE,
     data: CompileResult
 } |
 {
-    code: Status.WA,
+    code: Status.WA | Status.PE,
     data: WAResult
 } |
 {
-    code: Status.OK | Status.RE | Status.TLE | Status.MLE | Status.SE,
+    code: Status.PC,
+    data: PCResult
+} |
+{
+    code: Status.OK | Status.RE | Status.TLE | Status.MLE | Status.SE | Status.OLE,
     data: ExecResult
 }
 
@@ -123,27 +184,59 @@ export function formatReason(reason: Reason): string{
     return `[${reason.category}:${reason.id}] ${reason.message}`
 }
 
-export type LanguageSpec = { id: string, name: string };
+export interface Language {
+    id: number
+    name: string
+    displayName: string
+    highlight: string
+    extension: string
+    source?: string
+    version: string
+    timeMultiplier: number
+    memoryMultiplier: number
+}
 
-export const languages: LanguageSpec[] = [
+// Built-in languages, replaced with those of the server by backend.fetchLanguages().
+export let languages: Language[] = [
     {
-        id: 'c',
-        name: 'C'
+        id: 0,
+        name: 'c',
+        displayName: 'C',
+        highlight: 'c',
+        extension: '.c',
+        version: '',
+        timeMultiplier: 0,
+        memoryMultiplier: 0
     },
     {
-        id: 'cpp',
-        name: 'C++'
+        id: 1,
+        name: 'cpp',
+        displayName: 'C++',
+        highlight: 'cpp',
+        extension: '.cpp',
+        version: '',
+        timeMultiplier: 0,
+        memoryMultiplier: 0
     }
 ];
 
 export function formatLanguage(lang: number): string {
-    return languages[lang].name;
+    return languages.find(l => l.id === lang)?.displayName ?? `#${lang}`;
 }
 
 export function getLanguageId(lang: number): string {
-    return languages[lang].id;
+    return languages.find(l => l.id === lang)?.highlight ?? '';
 }
 
+// Built-in checkers, replacing lax comparison.
+export const checkers: Record<string, string> = {
+    token: '逐词比较',
+    float: '浮点数误差',
+    nocase: '忽略大小写',
+    unordered: '忽略行顺序',
+    yesno: 'YES / NO',
+};
+
 export function formatMode(mode: number): string {
     const modes = [];
     if ((mode & Mode.Strict) === Mode.Strict) {
@@ -155,12 +248,20 @@ export function formatMode(mode: number): string {
     if ((mode & Mode.Random) === Mode.Random) {
         modes.push('RandomJudge');
     }
+    if ((mode & Mode.ExitCheck) === Mode.ExitCheck) {
+        modes.push('ExitCheck');
+    }
+    if ((mode & Mode.Interactive) === Mode.Interactive) {
+        modes.push('Interactive');
+    }
     return modes.join(', ') || 'Lax';
 }
 
 export interface RecordEntry {
+    language?: number
     passed: number
     total: number
+    score?: number
     code: string[] | null
 }
 
@@ -171,6 +272,33 @@ export interface Record$ {
     entries: RecordEntry[]
 }
 
+// Whether a data point passed, see Objective.Passes.
+export function passes(objective: ObjectiveInfo, result: Result): boolean {
+    return result.code === Status.OK || (result.code === Status.PE && !!objective.acceptPE);
+}
+
+// Score of results as computed by the server, see Objective.Score.
+export function scoreOf(objective: ObjectiveInfo, results: Result[]): number {
+    if (results.length !== objective.pointCount || objective.pointCount <= 0) return 0;
+    const share = (r: Result) => passes(objective, r) ? 1 : r.code === Status.PC ? r.data.score : 0;
+    if (!objective.subtasks?.length) {
+        return results.map(share).reduce((a, b) => a+b) * 100 / objective.pointCount;
+    }
+    return objective.subtasks.map(subtask => {
+        const shares = subtask.points.filter(i => i >= 0 && i < results.length).map(i => {
+            const s = share(results[i]);
+            return subtask.scoring !== 'min' && s < 1 ? 0 : s;
+        });
+        return shares.length ? subtask.score * Math.min(1, ...shares) : 0;
+    }).reduce((a, b) => a+b);
+}
+
+// Highest score of an objective.
+export function maxScore(objective: ObjectiveInfo): number {
+    if (!objective.subtasks?.length) return 100;
+    return objective.subtasks.map(s => s.score).reduce((a, b) => a+b);
+}
+
 export function average(record: Record$): number {
     const sum = record.entries.map(e => e.total > 0 ? e.passed / e.total : 0).reduce((a, b) => a+b);
     return sum / record.entries.length;
@@ -185,13 +313,14 @@ export interface Backend {
     fetchRecordEntry(id: string, index: number): Promise<RecordEntry>
     fetchRecentRecords(limit: number): Promise<Record$[]>
     fetchGroups(): Promise<Record<string, number>>
+    fetchLanguages(): Promise<Language[]>
     createUnit(unit: PureUnit<Objective>): Promise<string>
     updateUnit(id: string, unit: PureUnit<Objective>): Promise<void>
     removeUnit(id: string): Promise<void>
     getCaptcha(): Promise<[string, string]>;
     login(credential: Credential, captcha: Captcha): Promise<void>
     password(passwords: Passwords, captcha: Captcha): Promise<void>
-    watchedRun(id: string, index: number, code: string[]): AsyncIterable<WatchMessage>
+    watchedRun(id: string, index: number, code: string[], language?: number): AsyncIterable<WatchMessage>
 }
 
 function getApiBase(): string {
@@ -201,11 +330,55 @@ function getApiBase(): string {
     return '/_api'
 }
 
+export interface TemplateError {
+    objective: number
+    language: number
+    // -1 if the template as a whole is at fault
+    region: number
+    message: string
+    compile?: CompileResult
+}
+
+// Reason of a rejected unit, pointing at the offending region.
+export interface TemplateReason extends Reason {
+    template: TemplateError
+}
+
+export function formatTemplateError(error: TemplateError): string {
+    const where = error.region >= 0 ? `Region #${error.region+1}` : '模板';
+    const detail = error.compile ? `\n${locateCompileError(error.compile)}` : '';
+    return `${formatLanguage(error.language)} ${where}：${error.message}${detail}`;
+}
+
+// Error in a part of an objective other than its templates, such as its subtasks.
+export interface ObjectiveError {
+    objective: number
+    field: string
+    message: string
+}
+
+// Reason of a rejected unit, pointing at the offending field.
+export interface ObjectiveReason extends Reason {
+    objective: ObjectiveError
+}
+
+export function formatObjectiveError(error: ObjectiveError): string {
+    return `${error.field}：${error.message}`;
+}
+
+// Reason of a rejected unit, with the error of the objective at fault if any.
+function unitRejection(data: any): Reason | TemplateReason | ObjectiveReason {
+    if (!data.data) return data.reason;
+    return 'field' in data.data ? { ...data.reason, objective: data.data } : { ...data.reason, template: data.data };
+}
+
 export class PrerunError extends Error {
     public readonly reason: Reason
-    constructor(reason: Reason) {
+    public readonly template?: TemplateError
+    constructor(reason: Reason, template?: TemplateError) {
         super(`Error prior to running: ${reason.message}`)
         this.reason = reason;
+        this.template = template;
     }
 }
 
@@ -214,19 +387,49 @@ export interface CompileResult {
     compiler: string
     exitCode: number
     error: string
+    timeout?: boolean
+    flags?: string[]
+    cached?: boolean
+    sourceMap?: SourceLine[]
+}
+
+// Origin of a line of joined code.
+export interface SourceLine {
+    region: number
+    line: number
+}
+
+// Annotates compiler messages referring to lines of joined code with their origins within the template.
+export function locateCompileError(result: CompileResult): string {
+    const sourceMap = result.sourceMap;
+    if (!sourceMap) return result.error;
+    return result.error.split('\n').map(line => {
+        const match = line.match(/(?:\S:|\bline )(\d+)/);
+        const origin = match && sourceMap[parseInt(match[1])-1];
+        return origin ? `${line}  [region #${origin.region+1}, line ${origin.line}]` : line;
+    }).join('\n');
 }
 
 export interface ExecResult {
     code: number
     execTime: number
+    wallTime: number
     execMemory: number
     syscall: number
     termsig: number
+    exitCode: number
+    stderr?: string
 }
 
 export interface WAResult extends ExecResult {
     got: string
     expected: string
+    message?: string
+}
+
+export interface PCResult extends ExecResult {
+    score: number
+    message: string
 }
 
 export const backend: Backend = {
@@ -302,6 +505,15 @@ export const backend: Backend = {
         if (!data.ok) throw data.reason;
         return data.data;
     },
+    async fetchLanguages() {
+        const resp = await fetch(getApiBase() + '/languages', {
+            credentials: 'include'
+        });
+        const data = await resp.json();
+        if (!data.ok) throw data.reason;
+        languages = data.data;
+        return data.data;
+    },
     async login(credential, captcha) {
         const resp = await fetch(getApiBase() + '/account/human/login', {
             credentials: 'include',
@@ -339,13 +551,14 @@ export const backend: Backend = {
             throw data.reason;
         }
     },
-    async *watchedRun(id, index, code) {
+    async *watchedRun(id, index, code, language) {
         const resp = await fetch(getApiBase() + '/run/watched/' + id, {
             credentials: 'include',
             method: 'POST',
             body: JSON.stringify({
                 index,
-                code
+                code,
+                language
             }),
             headers: {
                 'Content-Type': 'application/json'
@@ -354,7 +567,7 @@ export const backend: Backend = {
         if (resp.status !== 200) throw new Error(`Failed to run objective: server returned status code ${resp.status}`);
         if (resp.headers.get('Content-Type') == 'application/json') {
             const data = await resp.json();
-            throw new PrerunError(data.reason);
+            throw new PrerunError(data.reason, data.data);
         }
         const decoder = new TextDecoderStream('utf-8');
         const reader = resp.body!.pipeThrough(decoder).getReader();
@@ -398,7 +611,7 @@ export const backend: Backend = {
             },
         });
         const data = await resp.json();
-        if (!data.ok) throw data.reason;
+        if (!data.ok) throw unitRejection(data);
         return data.data;
     },
     async updateUnit(id, unit) {
@@ -411,7 +624,7 @@ export const backend: Backend = {
             },
         });
         const data = await resp.json();
-        if (!data.ok) throw data.reason;
+        if (!data.ok) throw unitRejection(data);
     },
     async removeUnit(id) {
         const resp = await fetch(getApiBase() + '/units/' + id, {
@@ -464,6 +677,21 @@ export function readToken(): Payload | undefined {
     return data;
 }
 
+// Languages accepted by an objective, its own language first.
+export function acceptedLanguages(objective: ObjectiveInfo): number[] {
+    return [objective.language, ...(objective.languages ?? []).map(l => l.language)];
+}
+
+// Template of an objective in given language, a single editable region if unrestricted.
+export function templateIn(objective: ObjectiveInfo, language: number): Region[] {
+    if (language === objective.language) return objective.template;
+    return objective.languages?.find(l => l.language === language)?.template ?? [{
+        content: '',
+        editable: true,
+        indent: 0
+    }];
+}
+
 export function initialCode(template: Region[]): string[] {
     return template.filter(r => r.editable).map(r => r.content);
 }
@@ -1,6 +1,6 @@
 import { HeadFC, PageProps, navigate } from "gatsby";
 import React from "react";
-import { Objective, backend, languages, render, getLanguageId, PureUnit, parseQuery } from "../frontend/api";
+import { Objective, backend, languages, render, getLanguageId, PureUnit, parseQuery, Reason, TemplateReason, ObjectiveReason, formatReason, formatTemplateError, formatObjectiveError, checkers } from "../frontend/api";
 import { AlertDialog, AlertDialogBody, AlertDialogCloseButton, AlertDialogContent, AlertDialogFooter, AlertDialogHeader, AlertDialogOverlay, Box, Button, FormControl, FormHelperText, FormLabel, Grid, GridItem, HStack, IconButton, Input, NumberDecrementStepper, NumberIncrementStepper, NumberInput, NumberInputField, NumberInputStepper, Popover, PopoverArrow, PopoverBody, PopoverContent, PopoverHeader, PopoverTrigger, Select, Slider, SliderFilledTrack, SliderThumb, SliderTrack, Stack, Switch, Tab, TabList, TabPanel, TabPanels, Tabs, Tag, TagCloseButton, TagLabel, TagRightIcon, Text, Textarea, useDisclosure, useToast } from "@chakra-ui/react";
 import { Navbar } from "../components/Navbar";
 import { IconArrowBarToLeft, IconArrowBarToRight, IconArrowLeft, IconArrowRight, IconCheck, IconCornerUpLeft, IconCornerUpRight, IconExclamationCircle, IconHelp, IconMarkdown, IconPlus, IconSettings, IconTrash, IconTrashX } from "@tabler/icons-react";
@@ -17,6 +17,30 @@ function formatDate(date: Date): string {
   return `${date.getFullYear()}-${(date.getMonth()+1).toString().padStart(2, '0')}-${date.getDate().toString().padStart(2, '0')}T${date.getHours().toString().padStart(2, '0')}:${date.getMinutes().toString().padStart(2, '0')}`;
 }
 
+// Parses 1-based point ranges such as "1-3,5" into sorted 0-based indices.
+function parsePoints(text: string): number[] {
+  const points = new Set<number>();
+  for (const part of text.split(',')) {
+    const [from, to] = part.split('-').map(s => parseInt(s.trim()));
+    if (isNaN(from)) continue;
+    for (let i = from; i <= (isNaN(to) ? from : to); i++) {
+      if (i >= 1) points.add(i-1);
+    }
+  }
+  return [...points].sort((a, b) => a-b);
+}
+
+function formatPoints(points: number[]): string {
+  const ranges: string[] = [];
+  for (let i = 0; i < points.length; i++) {
+    let j = i;
+    while (j+1 < points.length && points[j+1] === points[j]+1) j++;
+    ranges.push(i === j ? `${points[i]+1}` : `${points[i]+1}-${points[j]+1}`);
+    i = j;
+  }
+  return ranges.join(',');
+}
+
 function checkObjective(objective: Objective): string | null {
   if (!objective.name.length) {
     return "请指定问题名。"
@@ -24,6 +48,18 @@ function checkObjective(objective: Objective): string | null {
   if (!objective.description.length) {
     return "请指定问题描述。"
   }
+  for (const owner of [objective, ...(objective.languages ?? [])]) {
+    if (owner.harness && !owner.harness.signature.trim().length) {
+      return "请指定函数签名。";
+    }
+  }
+  const pointCount = objective.mode & 0b100 ? objective.pointCount : objective.points?.length ?? 0;
+  if (objective.subtasks?.some(s => !s.points.length)) {
+    return "子任务不能为空。";
+  }
+  if (objective.subtasks?.some(s => s.points.some(i => i < 0 || i >= pointCount))) {
+    return "子任务包含不存在的数据点。";
+  }
   if (objective.mode & 0b100) {
     if (!objective.pointCount) {
       return "请指定数据点数量。"
@@ -60,6 +96,9 @@ const DesignPage: React.FC<PageProps> = props => {
   const [addingGroup, setAddingGroup] = React.useState(false);
   const [active, setActive] = React.useState(-1);
   const [renderedDescription, setRenderedDescription] = React.useState('');
+  const [languageList, setLanguageList] = React.useState(languages);
+  // index into objective.languages of the template being edited, -1 for that of the objective
+  const [templateLanguage, setTemplateLanguage] = React.useState(-1);
   const descriptionUpdateRef = React.useRef<any>();
   const deleter = useDisclosure();
   const remover = useDisclosure();
@@ -68,7 +107,10 @@ const DesignPage: React.FC<PageProps> = props => {
   const toast = useToast({ position: 'top', duration: 4000 });
 
   const objective = unit.objectives[active];
-  const lines = active >= 0 ? objective.template.map(r => r.content.split('\n').length).reduce((a, b) => a+b) : 0;
+  const languageOption = active >= 0 && templateLanguage >= 0 ? objective.languages![templateLanguage] : undefined;
+  const template = active >= 0 ? (languageOption ? languageOption.template : objective.template) : null;
+  const harness = active >= 0 ? (languageOption ?? objective).harness : undefined;
+  const lines = template ? template.map(r => r.content.split('\n').length).reduce((a, b) => a+b) : 0;
 
   React.useEffect(() => {
     const query = parseQuery(props.location.search);
@@ -86,12 +128,14 @@ const DesignPage: React.FC<PageProps> = props => {
       })
     }
     backend.fetchGroups().then(setGroups);
+    backend.fetchLanguages().then(setLanguageList);
   }, []);
 
   React.useEffect(() => {
     if (active >= 0) {
       setRenderedDescription(DOMPurify.sanitize(render(objective.description)));
     }
+    setTemplateLanguage(-1);
   }, [active]);
   return <>
     <Navbar/>
@@ -302,6 +346,10 @@ const DesignPage: React.FC<PageProps> = props => {
           <HStack>
             <Button colorScheme='green' leftIcon={<IconCheck size={16}/>} flexGrow={1} isDisabled={!unit.objectives.length} onClick={() => {
               for (let i = 0; i < unit.objectives.length; i++) {
+                // subtasks are validated against pointCount, which only RandomJudge sets by hand
+                if (!(unit.objectives[i].mode & 0b100)) {
+                  unit.objectives[i].pointCount = unit.objectives[i].points?.length ?? 0;
+                }
                 const msg = checkObjective(unit.objectives[i]);
                 if (msg) {
                   toast({
@@ -311,12 +359,21 @@ const DesignPage: React.FC<PageProps> = props => {
                   })
                   return;
                 }
-                if (id) {
-                  backend.updateUnit(id, unit).then(() => navigate("/unit?id=" + id));
-                }
-                else {
-                  backend.createUnit(unit).then(() => navigate("/"));
-                }
+              }
+              const rejected = (reason: Reason | TemplateReason | ObjectiveReason) => {
+                const at = 'template' in reason ? reason.template.objective : 'objective' in reason ? reason.objective.objective : -1;
+                toast({
+                  title: at >= 0 ? `#${at+1} ${unit.objectives[at].name}` : '提交失败',
+                  description: 'template' in reason ? formatTemplateError(reason.template) :
+                    'objective' in reason ? formatObjectiveError(reason.objective) : formatReason(reason),
+                  status: 'error'
+                });
+              };
+              if (id) {
+                backend.updateUnit(id, unit).then(() => navigate("/unit?id=" + id)).catch(rejected);
+              }
+              else {
+                backend.createUnit(unit).then(() => navigate("/")).catch(rejected);
               }
             }}>提交</Button>
             { id ? 
@@ -379,9 +436,73 @@ const DesignPage: React.FC<PageProps> = props => {
                           setUnit({...unit});
                         }} value={objective.language}>
                           {
-                            languages.map((lang, index) => <option key={lang.id} value={index}>{lang.name}</option>)
+                            languageList.map(lang => <option key={lang.id} value={lang.id}>{lang.displayName}{lang.version ? ` (${lang.version})` : ''}</option>)
                           }
                         </Select>
+                        <Text fontWeight='bold' fontSize={14}>编译选项</Text>
+                        <Input size='sm' fontFamily='var(--mono-font)' placeholder='默认选项 (如 -std=c++17 -O2 -lm)' onChange={e => {
+                          objective.flags = e.target.value.split(' ');
+                          setUnit({...unit});
+                        }} value={objective.flags?.join(' ') ?? ''}/>
+                        <Text fontWeight='bold' fontSize={14}>时间倍率</Text>
+                        <NumberInput size='sm' min={0} step={0.5} value={objective.timeMultiplier || ''} onChange={(_, n) => {
+                          objective.timeMultiplier = isNaN(n) ? undefined : n;
+                          setUnit({...unit});
+                        }}>
+                          <NumberInputField placeholder='1 (在语言本身的倍率之上)'/>
+                          <NumberInputStepper>
+                            <NumberIncrementStepper/>
+                            <NumberDecrementStepper/>
+                          </NumberInputStepper>
+                        </NumberInput>
+                        <HStack>
+                          <Text fontWeight='bold' fontSize={14} flexGrow={1}>其他语言</Text>
+                          <IconButton aria-label="add language" size='xs' title='添加语言' isDisabled={languageList.length <= 1 + (objective.languages?.length ?? 0)} onClick={() => {
+                            const used = [objective.language, ...(objective.languages ?? []).map(l => l.language)];
+                            const lang = languageList.find(l => !used.includes(l.id));
+                            if (!lang) return;
+                            (objective.languages || (objective.languages = [])).push({
+                              language: lang.id,
+                              template: null,
+                            });
+                            setUnit({...unit});
+                          }}>
+                            <IconPlus size={14}/>
+                          </IconButton>
+                        </HStack>
+                        {
+                          objective.languages?.map((option, index) => 
+                          <HStack key={index}>
+                            <Select size='sm' w='40%' onChange={e => {
+                              option.language = parseInt(e.target.value);
+                              setUnit({...unit});
+                            }} value={option.language}>
+                              {
+                                languageList.map(lang => <option key={lang.id} value={lang.id}>{lang.displayName}</option>)
+                              }
+                            </Select>
+                            <Input size='sm' fontFamily='var(--mono-font)' placeholder='编译选项' onChange={e => {
+                              option.flags = e.target.value.split(' ');
+                              setUnit({...unit});
+                            }} value={option.flags?.join(' ') ?? ''}/>
+                            <NumberInput size='sm' w='30%' min={0} step={0.5} value={option.timeMultiplier || ''} onChange={(_, n) => {
+                              option.timeMultiplier = isNaN(n) ? undefined : n;
+                              setUnit({...unit});
+                            }}>
+                              <NumberInputField placeholder='倍率'/>
+                            </NumberInput>
+                            <IconButton aria-label="remove language" size='sm' title='移除语言' onClick={() => {
+                              objective.languages!.splice(index, 1);
+                              if (!objective.languages!.length) {
+                                objective.languages = undefined;
+                              }
+                              setTemplateLanguage(-1);
+                              setUnit({...unit});
+                            }}>
+                              <IconTrashX size={14}/>
+                            </IconButton>
+                          </HStack>)
+                        }
                         <HStack gap={1}>
                           <Text as='span' fontWeight='bold' fontSize={14}>
                             描述
@@ -414,15 +535,79 @@ const DesignPage: React.FC<PageProps> = props => {
                 <TabPanel h='calc(100vh - 75px - .5rem - 15px - 40px)' overflowY='auto'>
                   <Grid templateColumns='repeat(2, 1fr)' gap={2}>
                     <GridItem colSpan={1}>
-                      <Button size='sm' colorScheme="green" onClick={() => {
-                        objective.template.push({
-                          content: '',
-                          editable: true,
-                          indent: 0,
-                        })
-                        setUnit({...unit});
-                      }}>添加 Region</Button>
-                      <HStack gap={0} mt={2} bg='#1e1e1e'>
+                      <HStack>
+                        <Button size='sm' colorScheme="green" isDisabled={!template || !!harness} onClick={() => {
+                          template!.push({
+                            content: '',
+                            editable: true,
+                            indent: 0,
+                          })
+                          setUnit({...unit});
+                        }}>添加 Region</Button>
+                        {
+                          objective.languages?.length ?
+                          <Select size='sm' w='auto' onChange={e => setTemplateLanguage(parseInt(e.target.value))} value={templateLanguage}>
+                            <option value={-1}>{languageList.find(l => l.id === objective.language)?.displayName ?? `#${objective.language}`}</option>
+                            {
+                              objective.languages.map((option, index) => 
+                                <option key={index} value={index}>{languageList.find(l => l.id === option.language)?.displayName ?? `#${option.language}`}</option>
+                              )
+                            }
+                          </Select> : undefined
+                        }
+                        {
+                          languageOption ?
+                          <Button size='sm' variant='outline' onClick={() => {
+                            languageOption.template = languageOption.template ? null : structuredClone(objective.template);
+                            setUnit({...unit});
+                          }} isDisabled={!!harness}>{languageOption.template ? '不限制输入' : '使用独立模板'}</Button> : undefined
+                        }
+                        <Button size='sm' variant='outline' onClick={() => {
+                          const owner = languageOption ?? objective;
+                          owner.harness = owner.harness ? null : {
+                            header: '',
+                            signature: '',
+                            footer: '',
+                            driver: '',
+                          };
+                          setUnit({...unit});
+                        }}>{harness ? '区域模式' : '函数模式'}</Button>
+                      </HStack>
+                      {
+                        template || harness ? undefined :
+                        <Text mt={2} color='whiteAlpha.600'>
+                          此语言不限制输入区域。
+                        </Text>
+                      }
+                      {
+                        harness ?
+                        <Stack mt={2}>
+                          <Text fontSize={14} color='whiteAlpha.600'>
+                            学生只编写函数体，驱动代码不会发送给学生。
+                          </Text>
+                          <Text fontWeight='bold' fontSize={14}>头部</Text>
+                          <Textarea size='sm' fontFamily='var(--mono-font)' spellCheck={false} placeholder='#include <cstdio>' onChange={e => {
+                            harness.header = e.target.value;
+                            setUnit({...unit});
+                          }} value={harness.header}/>
+                          <Text fontWeight='bold' fontSize={14}>函数签名</Text>
+                          <Input size='sm' fontFamily='var(--mono-font)' spellCheck={false} placeholder='int add(int a, int b) {' onChange={e => {
+                            harness.signature = e.target.value;
+                            setUnit({...unit});
+                          }} value={harness.signature}/>
+                          <Text fontWeight='bold' fontSize={14}>函数结尾</Text>
+                          <Input size='sm' fontFamily='var(--mono-font)' spellCheck={false} placeholder='} (以缩进分隔的语言留空)' onChange={e => {
+                            harness.footer = e.target.value;
+                            setUnit({...unit});
+                          }} value={harness.footer}/>
+                          <Text fontWeight='bold' fontSize={14}>驱动代码</Text>
+                          <Textarea size='sm' rows={10} fontFamily='var(--mono-font)' spellCheck={false} placeholder='int main() { ... }' onChange={e => {
+                            harness.driver = e.target.value;
+                            setUnit({...unit});
+                          }} value={harness.driver ?? ''}/>
+                        </Stack> : undefined
+                      }
+                      <HStack gap={0} mt={2} bg='#1e1e1e' display={harness ? 'none' : 'flex'}>
                         <Box px={2} justifySelf='flex-start' mt='1.5px'>
                           { 
                             new Array(lines).fill(0).map((_, index) => 
@@ -432,14 +617,14 @@ const DesignPage: React.FC<PageProps> = props => {
                         </Box>
                         <Box flexGrow={1}>
                           {
-                            objective.template.map((region, index) => {
+                            template?.map((region, index) => {
                               return <HStack gap={0} key={index} borderTopColor='#ffffff17' borderTopWidth={index > 0 ? 1 : 0}>
                                 <Box w={`${region.indent*width}px`}></Box>
                                 <CodeMirror
                                   style={{ flexGrow: 1 }}
                                   theme={vscodeDark} 
                                   value={region.content}
-                                  extensions={[ loadLanguage(getLanguageId(objective.language) as keyof typeof langs)! ]} 
+                                  extensions={[ loadLanguage(getLanguageId(languageOption ? languageOption.language : objective.language) as keyof typeof langs) ?? [] ]} 
                                   basicSetup={{ lineNumbers: false, tabSize: 4 }}
                                   onChange={value => {
                                     region.content = value;
@@ -475,8 +660,8 @@ const DesignPage: React.FC<PageProps> = props => {
                                               </NumberInputStepper>
                                             </NumberInput>
                                           </HStack>
-                                          <Button colorScheme='red' size='sm' isDisabled={objective.template.length <= 1} onClick={() => {
-                                            objective.template.splice(index, 1);
+                                          <Button colorScheme='red' size='sm' isDisabled={template!.length <= 1} onClick={() => {
+                                            template!.splice(index, 1);
                                             setUnit({...unit});
                                           }}>删除</Button>
                                         </Stack>
@@ -513,7 +698,10 @@ const DesignPage: React.FC<PageProps> = props => {
                         - in: string, 输入内容<br/>
                         - out: string, 输出内容<br/>
                         - timeLimit: number, 时间限制(秒)，0为不限。<br/>
+                        - cpuTimeLimit: number, 可选，CPU 时间限制(毫秒)，设置后取代 timeLimit。<br/>
+                        - wallTimeLimit: number, 可选，墙上时间限制(毫秒)，默认为 CPU 时间限制的两倍。<br/>
                         - memoryLimit: number, 内存限制(B)，0为不限。<br/>
+                        - outputLimit: number, 可选，输出限制(B)，默认使用配置中的 OutputLimit。<br/>
                       </Text>
                       }
                       <Stack mt={2} gap={0}>
@@ -530,10 +718,60 @@ const DesignPage: React.FC<PageProps> = props => {
                           <Box flexGrow={1}/>
                           <Text fontSize={14} fontFamily='var(--mono-font)' color='blue.500'>IN: {point.in.length} bytes</Text>
                           <Text fontSize={14} fontFamily='var(--mono-font)' color='green.500'>OUT: {point.out.length} bytes</Text>
-                          <Text fontSize={14} fontFamily='var(--mono-font)' color='yellow.500'>{point.timeLimit}s, {point.memoryLimit}B</Text>
+                          <Text fontSize={14} fontFamily='var(--mono-font)' color='yellow.500'>{point.cpuTimeLimit ? `${point.cpuTimeLimit}ms` : `${point.timeLimit}s`}, {point.memoryLimit}B</Text>
                         </HStack>
                         )}
                       </Stack>
+                      <HStack mt={4}>
+                        <Text fontWeight='bold' fontSize={14} flexGrow={1}>子任务</Text>
+                        <IconButton aria-label="add subtask" size='xs' title='添加子任务' onClick={() => {
+                          (objective.subtasks || (objective.subtasks = [])).push({
+                            score: 0,
+                            points: [],
+                          });
+                          setUnit({...unit});
+                        }}>
+                          <IconPlus size={14}/>
+                        </IconButton>
+                      </HStack>
+                      { objective.subtasks?.length ? undefined :
+                      <Text fontSize={14} mt={2} color='whiteAlpha.600'>
+                        未设置子任务时，所有数据点平分 100 分。
+                      </Text>
+                      }
+                      {
+                        objective.subtasks?.map((subtask, index) => 
+                        <HStack key={`${active}-${index}`} mt={2}>
+                          <Text fontSize={14} fontFamily='var(--mono-font)'>#{index+1}</Text>
+                          <NumberInput size='sm' w='25%' min={0} value={subtask.score} onChange={(_, n) => {
+                            subtask.score = isNaN(n) ? 0 : n;
+                            setUnit({...unit});
+                          }}>
+                            <NumberInputField placeholder='分值'/>
+                          </NumberInput>
+                          <Input size='sm' fontFamily='var(--mono-font)' placeholder='数据点，如 1-3,5' defaultValue={formatPoints(subtask.points)} onBlur={e => {
+                            subtask.points = parsePoints(e.target.value);
+                            e.target.value = formatPoints(subtask.points);
+                            setUnit({...unit});
+                          }}/>
+                          <Select size='sm' w='35%' value={subtask.scoring ?? 'all'} onChange={e => {
+                            subtask.scoring = e.target.value as 'all' | 'min';
+                            setUnit({...unit});
+                          }}>
+                            <option value='all'>全部通过</option>
+                            <option value='min'>取最低</option>
+                          </Select>
+                          <IconButton aria-label="remove subtask" size='sm' title='删除子任务' onClick={() => {
+                            objective.subtasks!.splice(index, 1);
+                            if (!objective.subtasks!.length) {
+                              objective.subtasks = null;
+                            }
+                            setUnit({...unit});
+                          }}>
+                            <IconTrashX size={14}/>
+                          </IconButton>
+                        </HStack>)
+                      }
                     </GridItem>
                   </Grid>
                 </TabPanel>
@@ -552,6 +790,70 @@ const DesignPage: React.FC<PageProps> = props => {
                   </HStack>
                   <Text mt={2} fontSize={14} color='whiteAlpha.600'>
                     Strict 将以最严格的方式进行评判（前置与后置空行、行前与行尾空格均视为错误答案）。
+                    仅空行或行首尾空格不同的输出结果为格式错误 (PE)。
+                  </Text>
+                  <HStack mt={2}>
+                    <Text fontSize={14}>
+                      PE 计为通过
+                    </Text>
+                    <Switch isChecked={!!objective.acceptPE} onChange={() => {
+                      objective.acceptPE = !objective.acceptPE || undefined;
+                      setUnit({...unit});
+                    }}></Switch>
+                  </HStack>
+                  <HStack mt={2}>
+                    <Text fontSize={14} flexShrink={0}>
+                      比较方式
+                    </Text>
+                    <Select size='sm' onChange={e => {
+                      objective.checker = e.target.value || undefined;
+                      setUnit({...unit});
+                    }} value={objective.checker ?? ''} isDisabled={(objective.mode & 0b011) !== 0}>
+                      <option value=''>默认 (忽略行首尾空格与空行)</option>
+                      {
+                        Object.entries(checkers).map(([name, display]) => <option key={name} value={name}>{display}</option>)
+                      }
+                    </Select>
+                    {
+                      objective.checker === 'float' ?
+                      <NumberInput size='sm' min={0} value={objective.epsilon || ''} onChange={(_, n) => {
+                        objective.epsilon = isNaN(n) ? undefined : n;
+                        setUnit({...unit});
+                      }}>
+                        <NumberInputField placeholder='误差 (1e-6)'/>
+                      </NumberInput> : undefined
+                    }
+                  </HStack>
+                  <Text mt={2} fontSize={14} color='whiteAlpha.600'>
+                    内置比较方式取代默认比较，启用 Strict 或 SpecialJudge 时不生效。
+                  </Text>
+                  <HStack mt={2}>
+                    <Text fontSize={14}>
+                      非零退出码视为 RE
+                    </Text>
+                    <Switch isChecked={(objective.mode & 0b1000) !== 0} onChange={() => {
+                      objective.mode ^= 0b1000;
+                      setUnit({...unit});
+                    }}></Switch>
+                  </HStack>
+                  <HStack mt={2}>
+                    <Text fontSize={14} flexShrink={0}>
+                      输入文件
+                    </Text>
+                    <Input size='sm' placeholder='stdin' _placeholder={{ fontFamily: 'var(--mono-font)'}} onChange={e => {
+                      objective.inputFile = e.target.value;
+                      setUnit({...unit});
+                    }} value={objective.inputFile ?? ''}/>
+                    <Text fontSize={14} flexShrink={0}>
+                      输出文件
+                    </Text>
+                    <Input size='sm' placeholder='stdout' _placeholder={{ fontFamily: 'var(--mono-font)'}} onChange={e => {
+                      objective.outputFile = e.target.value;
+                      setUnit({...unit});
+                    }} value={objective.outputFile ?? ''}/>
+                  </HStack>
+                  <Text mt={2} fontSize={14} color='whiteAlpha.600'>
+                    留空则使用标准输入 / 输出。指定文件名后，程序将在独立的工作目录中读写这些文件，且只能打开这两个文件。
                   </Text>
                   <Grid templateColumns='repeat(2, 1fr)' gap={2} mt={2}>
                     <GridItem colSpan={1}>
@@ -631,10 +933,131 @@ const DesignPage: React.FC<PageProps> = props => {
                       : 
                       <Text mt={2} color='whiteAlpha.600' fontSize={14}>
                         用户与样例输出将以参数传入 SpecialJudge，以达到自定义评测的效果。<br/>
+                        也可以使用 testlib 检查器 (C++) 代替脚本。<br/>
                         Strict 和 SpecialJudge 不兼容。
                       </Text> }
                     </GridItem>
                   </Grid>
+                  <HStack mt={2}>
+                    <Text fontSize={14}>
+                      使用参考程序
+                    </Text>
+                    <Switch isChecked={!!objective.reference} onChange={() => {
+                      objective.reference = objective.reference ? null : { code: '', language: objective.language };
+                      setUnit({...unit});
+                    }}></Switch>
+                  </HStack>
+                  { objective.reference ?
+                  <Box mt={2}>
+                    <HStack mb={2}>
+                      <Select size='sm' w='40%' onChange={e => {
+                        objective.reference!.language = parseInt(e.target.value);
+                        setUnit({...unit});
+                      }} value={objective.reference.language}>
+                        {
+                          languageList.map(lang => <option key={lang.id} value={lang.id}>{lang.displayName}</option>)
+                        }
+                      </Select>
+                      <Input size='sm' fontFamily='var(--mono-font)' placeholder='编译选项' onChange={e => {
+                        objective.reference!.flags = e.target.value.split(' ');
+                        setUnit({...unit});
+                      }} value={objective.reference.flags?.join(' ') ?? ''}/>
+                    </HStack>
+                    <CodeMirror
+                      style={{ flexGrow: 1 }}
+                      theme={vscodeDark}
+                      extensions={[ loadLanguage(getLanguageId(objective.reference.language) as keyof typeof langs) ?? [] ]} 
+                      basicSetup={{ lineNumbers: true, tabSize: 4 }}
+                      value={objective.reference.code}
+                      onChange={val => {
+                        objective.reference!.code = val;
+                        setUnit({...unit});
+                      }}
+                      />
+                  </Box>
+                  :
+                  <Text mt={2} color='whiteAlpha.600' fontSize={14}>
+                    参考程序在每个数据点 (包括 RandomJudge 生成的数据点) 的输入上运行，其标准输出将代替数据点的输出。
+                  </Text> }
+                  <Grid templateColumns='repeat(2, 1fr)' gap={2} mt={2}>
+                    <GridItem colSpan={1}>
+                      <Text fontWeight='bold' fontSize={14} mb={2}>testlib 检查器 (C++，优先于 SpecialJudge 脚本)</Text>
+                      { objective.mode & 0b010 ?
+                      <CodeMirror
+                        style={{ flexGrow: 1 }}
+                        theme={vscodeDark}
+                        extensions={[ langs.cpp() ]} 
+                        basicSetup={{ lineNumbers: true, tabSize: 4 }}
+                        value={objective.nativeChecker ?? ''}
+                        onChange={val => {
+                          objective.nativeChecker = val;
+                          setUnit({...unit});
+                        }}
+                        />
+                      :
+                      <Text color='whiteAlpha.600' fontSize={14}>
+                        启用 SpecialJudge 后可用。检查器的退出码决定结果，quitp 给出的部分分计入得分。
+                      </Text> }
+                    </GridItem>
+                    <GridItem colSpan={1}>
+                      <Text fontWeight='bold' fontSize={14} mb={2}>testlib 校验器 (C++)</Text>
+                      <CodeMirror
+                        style={{ flexGrow: 1 }}
+                        theme={vscodeDark}
+                        extensions={[ langs.cpp() ]} 
+                        basicSetup={{ lineNumbers: true, tabSize: 4 }}
+                        value={objective.validator ?? ''}
+                        onChange={val => {
+                          objective.validator = val;
+                          setUnit({...unit});
+                        }}
+                        />
+                    </GridItem>
+                  </Grid>
+                  <HStack mt={2}>
+                    <Text fontSize={14}>
+                      启用 Interactive
+                    </Text>
+                    <Switch isChecked={(objective.mode & 0b10000) !== 0} onChange={() => {
+                      objective.mode ^= 0b10000;
+                      setUnit({...unit});
+                    }}></Switch>
+                  </HStack>
+                  { objective.mode & 0b10000 ?
+                  <Grid templateColumns='repeat(2, 1fr)' gap={2} mt={2}>
+                    <GridItem colSpan={1}>
+                      <Text fontWeight='bold' fontSize={14} mb={2}>交互脚本 (Lua)</Text>
+                      <CodeMirror
+                        style={{ flexGrow: 1 }}
+                        theme={vscodeDark}
+                        extensions={[ langs.lua() ]} 
+                        basicSetup={{ lineNumbers: true, tabSize: 4 }}
+                        value={objective.iScript ?? ''}
+                        onChange={val => {
+                          objective.iScript = val;
+                          setUnit({...unit});
+                        }}
+                        />
+                    </GridItem>
+                    <GridItem colSpan={1}>
+                      <Text fontWeight='bold' fontSize={14} mb={2}>原生交互器 (C++，优先于脚本)</Text>
+                      <CodeMirror
+                        style={{ flexGrow: 1 }}
+                        theme={vscodeDark}
+                        extensions={[ langs.cpp() ]} 
+                        basicSetup={{ lineNumbers: true, tabSize: 4 }}
+                        value={objective.interactor ?? ''}
+                        onChange={val => {
+                          objective.interactor = val;
+                          setUnit({...unit});
+                        }}
+                        />
+                    </GridItem>
+                  </Grid>
+                  :
+                  <Text mt={2} color='whiteAlpha.600' fontSize={14}>
+                    交互器将代替固定的输入与输出，与运行中的程序逐行交换数据并给出评测结果。
+                  </Text> }
                 </TabPanel>
               </TabPanels>
             </Tabs>
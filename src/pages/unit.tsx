@@ -1,7 +1,7 @@
 import { HeadFC, PageProps } from "gatsby";
 import { Navbar } from "../components/Navbar";
-import { ObjectiveInfo, Reason, Result, Status, Unit, backend, formatLanguage, getLanguageId, formatMode, formatReason, initialCode, render, parseQuery } from "../frontend/api";
-import { AlertDialog, AlertDialogBody, AlertDialogCloseButton, AlertDialogContent, AlertDialogFooter, AlertDialogHeader, AlertDialogOverlay, Box, Button, ButtonGroup, Card, CardBody, CircularProgress, CircularProgressLabel, Drawer, DrawerBody, DrawerCloseButton, DrawerContent, DrawerHeader, DrawerOverlay, Grid, GridItem, HStack, IconButton, Modal, ModalBody, ModalContent, ModalOverlay, Spinner, Stack, StatHelpText, Text, useDisclosure } from "@chakra-ui/react";
+import { ObjectiveInfo, Reason, Result, Status, Unit, backend, formatLanguage, getLanguageId, languages, formatMode, formatReason, initialCode, render, parseQuery, acceptedLanguages, templateIn, PrerunError, formatTemplateError, locateCompileError, passes, scoreOf, maxScore, checkers } from "../frontend/api";
+import { AlertDialog, AlertDialogBody, AlertDialogCloseButton, AlertDialogContent, AlertDialogFooter, AlertDialogHeader, AlertDialogOverlay, Box, Button, ButtonGroup, Card, CardBody, CircularProgress, CircularProgressLabel, Drawer, DrawerBody, DrawerCloseButton, DrawerContent, DrawerHeader, DrawerOverlay, Grid, GridItem, HStack, IconButton, Modal, ModalBody, ModalContent, ModalOverlay, Select, Spinner, Stack, StatHelpText, Text, useDisclosure, useToast } from "@chakra-ui/react";
 import React from "react";
 import { IconCheck, IconCircleFilled, IconExclamationCircle, IconPlayerPlayFilled, IconPlayerSkipBackFilled, IconX } from "@tabler/icons-react";
 import AniLink from "gatsby-plugin-transition-link/AniLink";
@@ -15,10 +15,12 @@ import { height, width } from '../frontend/const';
 
 interface State {
   staged: boolean
+  language: number
   code: string[],
   results?: Result[]
   passed: number
   total: number
+  score: number
 }
 
 const sum = (a: number, b: number) => a + b;
@@ -35,22 +37,31 @@ const UnitPage: React.FC<PageProps> = props => {
   const runner = useDisclosure();
   const errorDrawer = useDisclosure();
   const cancelRef = React.useRef<HTMLButtonElement>(null);
+  const toast = useToast({ position: 'top', duration: 4000 });
   React.useEffect(() => {
     const query = parseQuery(props.location.search);
-    backend.fetchUnit(query.id)
+    backend.fetchLanguages().catch(() => languages)
+    .then(() => backend.fetchUnit(query.id))
     .then(unit => {
       setUnit(unit);
       const states = unit.objectives.map(obj => ({
         staged: false,
+        language: obj.language,
         code: initialCode(obj.template),
         passed: 0,
-        total: obj.pointCount
+        total: obj.pointCount,
+        score: 0
       }));
       backend.fetchRecord(unit.id).then(record => {
         states.forEach((state, index) => {
           if (record.entries[index].code) {
+            const language = record.entries[index].language ?? unit.objectives[index].language;
             state.passed = record.entries[index].passed;
+            state.score = record.entries[index].score ?? 0;
             state.code = record.entries[index].code as string[];
+            if (acceptedLanguages(unit.objectives[index]).includes(language)) {
+              state.language = language;
+            }
           }
         });
         setStates(states);
@@ -70,14 +81,15 @@ const UnitPage: React.FC<PageProps> = props => {
     if (!unit) return;
     let tei = 0;
     setLines(
-      unit.objectives[selected].template.map(region => {
+      templateIn(unit.objectives[selected], states[selected].language).map(region => {
         if (region.editable) return states[selected].code[tei++];
         return region.content;
       }).map(s => s.split('\n').length).reduce(sum)
     );
-  }, [selected]);
+  }, [selected, states[selected]?.language]);
   const state = selected >= 0 && states[selected];
   const objective = selected >= 0 && unit?.objectives[selected];
+  const template = objective && state ? templateIn(objective, state.language) : [];
   let editableIndex = 0;
   return <>
     <Box h='100%'>
@@ -163,12 +175,40 @@ const UnitPage: React.FC<PageProps> = props => {
                     </Text>
                     <Difficulty value={objective.difficulty} postfix/>
                   </HStack>
-                  <Text>
-                    编程语言：{formatLanguage(objective.language)}
-                  </Text>
+                  {
+                    objective.languages?.length ?
+                    <HStack gap={0}>
+                      <Text>编程语言：</Text>
+                      <Select size='xs' variant='unstyled' w='auto' value={(state as State).language} onChange={e => {
+                        const language = parseInt(e.target.value);
+                        (state as State).language = language;
+                        (state as State).code = initialCode(templateIn(objective, language));
+                        (state as State).results = undefined;
+                        (state as State).staged = true;
+                        setStates([...states]);
+                      }}>
+                        {
+                          acceptedLanguages(objective).map(lang => <option key={lang} value={lang}>{formatLanguage(lang)}</option>)
+                        }
+                      </Select>
+                    </HStack> :
+                    <Text>
+                      编程语言：{formatLanguage(objective.language)}
+                    </Text>
+                  }
                   <Text>
                     模式：{formatMode(objective.mode)}
                   </Text>
+                  {
+                    objective.checker ? <Text>
+                      比较：{checkers[objective.checker] ?? objective.checker}{objective.checker === 'float' ? ` (${objective.epsilon || 1e-6})` : ''}
+                    </Text> : undefined
+                  }
+                  {
+                    objective.inputFile || objective.outputFile ? <Text>
+                      文件：{objective.inputFile || 'stdin'} / {objective.outputFile || 'stdout'}
+                    </Text> : undefined
+                  }
                 </HStack>
                 <div dangerouslySetInnerHTML={{ __html: render(objective.description) }} className="md"></div>
               </Box>
@@ -187,7 +227,7 @@ const UnitPage: React.FC<PageProps> = props => {
               <Box flexGrow={1}>
                 {
                   objective && state ?
-                  objective.template.map((region, index) => {
+                  template.map((region, index) => {
                     const ei = region.editable ? editableIndex++ : 0;
                     return <HStack gap={0} key={index}>
                       <Box w={`${region.indent*width}px`}></Box>
@@ -196,13 +236,13 @@ const UnitPage: React.FC<PageProps> = props => {
                         style={{ flexGrow: 1 }}
                         theme={vscodeDark} 
                         value={region.editable ? (state as State).code[ei] : region.content}
-                        extensions={[ loadLanguage(getLanguageId(objective.language) as keyof typeof langs)! ]} 
+                        extensions={[ loadLanguage(getLanguageId((state as State).language) as keyof typeof langs) ?? [] ]} 
                         basicSetup={{ lineNumbers: false, tabSize: 4 }}
                         onChange={value => {
                           (state as State).code[ei] = value;
                           let tei = 0;
                           setLines(
-                            objective.template.map(region => {
+                            template.map(region => {
                               if (region.editable) return (state as State).code[tei++];
                               return region.content;
                             }).map(s => s.split('\n').length).reduce(sum)
@@ -231,27 +271,44 @@ const UnitPage: React.FC<PageProps> = props => {
                 <IconButton aria-label="run" icon={<IconPlayerPlayFilled/>} title='运行程序' onClick={async () => {
                   const code = (state as State).code;
                   runner.onOpen();
-                  for await (const message of backend.watchedRun((unit as Unit<ObjectiveInfo>).id, selected, code)) {
-                    if (message.position >= 0) setQueuePos(message.position);
-                    else {
-                      runner.onClose();
-                      (state as State).results = message.results;
-                      (state as State).passed = message.results!.filter(r => r.code === Status.OK).length;
-                      (state as State).staged = false;
+                  try {
+                    for await (const message of backend.watchedRun((unit as Unit<ObjectiveInfo>).id, selected, code, (state as State).language)) {
+                      if (message.position >= 0) setQueuePos(message.position);
+                      else {
+                        runner.onClose();
+                        (state as State).results = message.results;
+                        (state as State).passed = message.results!.filter(r => passes(objective as ObjectiveInfo, r)).length;
+                        (state as State).score = scoreOf(objective as ObjectiveInfo, message.results!);
+                        (state as State).staged = false;
+                      }
                     }
                   }
+                  catch (e) {
+                    runner.onClose();
+                    if (!(e instanceof PrerunError)) throw e;
+                    toast({
+                      title: '无法运行',
+                      description: e.template ? formatTemplateError(e.template) : formatReason(e.reason),
+                      status: 'error'
+                    });
+                  }
                 }}/>
                 <IconButton aria-label="run" icon={<IconPlayerSkipBackFilled/>} title='回退到通过率最高的版本' onClick={async () => {
                   const entry = await backend.fetchRecordEntry(unit.id, selected);
                   if (entry.code != null) {
+                    const language = entry.language ?? (objective as ObjectiveInfo).language;
+                    if (acceptedLanguages(objective as ObjectiveInfo).includes(language)) {
+                      (state as State).language = language;
+                    }
                     (state as State).code = entry.code;
                     (state as State).passed = entry.passed;
+                    (state as State).score = entry.score ?? 0;
                     (state as State).results = undefined;
                   }
                   setStates([...states]);
                   let tei = 0;
                   setLines(
-                    (objective as ObjectiveInfo).template.map(region => {
+                    templateIn(objective as ObjectiveInfo, (state as State).language).map(region => {
                       if (region.editable) return states[selected].code[tei++];
                       return region.content;
                     }).map(s => s.split('\n').length).reduce(sum)
@@ -286,7 +343,11 @@ const UnitPage: React.FC<PageProps> = props => {
                         <Text as='span' color='red.300'>
                           编译错误
                         </Text>:
-                        state.results.every(r => r.code === Status.OK) ? 
+                        state.results.length === 1 && state.results[0].code === Status.CTLE ? 
+                        <Text as='span' color='red.300'>
+                          编译超时
+                        </Text>:
+                        state.results.every(r => passes(objective as ObjectiveInfo, r)) ? 
                         <Text as='span' color='green.300'>
                           通过
                         </Text> : 
@@ -298,6 +359,12 @@ const UnitPage: React.FC<PageProps> = props => {
                           未测试
                         </Text>}
                       </Text>
+                      {
+                        objective && objective.subtasks?.length ?
+                        <Text textAlign='center' fontSize={14} color='whiteAlpha.700'>
+                          得分 {+state.score.toFixed(2)} / {maxScore(objective)}
+                        </Text> : undefined
+                      }
                     </GridItem>
                   </Grid>
                 </>
@@ -355,12 +422,18 @@ const UnitPage: React.FC<PageProps> = props => {
         <DrawerBody>
           {
             state && state.results ? 
-            state.results.length === 1 && state.results[0].code === Status.CE ? 
+            state.results.length === 1 && (state.results[0].code === Status.CE || state.results[0].code === Status.CTLE) ? 
             <>
               <Text fontWeight={600}>
                 Compiler "{state.results[0].data.compiler}" exited with code {state.results[0].data.exitCode}.
               </Text>
-              <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ state.results[0].data.error }</code></pre>
+              {
+                state.results[0].data.flags?.length ?
+                <Text fontSize={14} color='whiteAlpha.600'>
+                  Flags: <code style={{ fontFamily: 'var(--mono-font)' }}>{ state.results[0].data.flags.join(' ') }</code>
+                </Text> : null
+              }
+              <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ locateCompileError(state.results[0].data) }</code></pre>
             </>
             : 
             <HStack flexWrap='wrap' alignItems='stretch'>
@@ -373,38 +446,79 @@ const UnitPage: React.FC<PageProps> = props => {
                       <Text>
                         {
                           result.code === Status.IE ? <Text as='span' color='red.300'>IE</Text> :
+                          result.code === Status.SK ? <Text as='span' color='whiteAlpha.600'>SK</Text> :
                           result.code === Status.WA ? <Text as='span' color='red.300'>WA</Text> :
+                          result.code === Status.PE ? <Text as='span' color='red.300'>PE</Text> :
+                          result.code === Status.PC ? <Text as='span' color='yellow.300'>PC</Text> :
                           result.code === Status.OK ? <Text as='span' color='green.300'>OK</Text> :
                           result.code === Status.RE ? <Text as='span' color='yellow.300'>RE</Text> :
                           result.code === Status.TLE ? <Text as='span' color='yellow.300'>TLE</Text> : 
                           result.code === Status.MLE ? <Text as='span' color='yellow.300'>MLE</Text> : 
-                          result.code === Status.SE ? <Text as='span' color='yellow.300'>SE</Text> : undefined
+                          result.code === Status.SE ? <Text as='span' color='yellow.300'>SE</Text> : 
+                          result.code === Status.OLE ? <Text as='span' color='yellow.300'>OLE</Text> : undefined
                         }
                       </Text>
                     </HStack>
                     <Box fontSize={14}>
                       {
                         result.code === Status.IE ? <Text as='span'>内部错误：{result.data}</Text> :
-                        result.code === Status.WA ? <>
-                          <Text>
-                            期望：
-                          </Text>
-                          <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ result.data.expected }</code></pre>
-                          <Text>
-                            得到：
+                        result.code === Status.SK ? <Text as='span'>所属子任务已失败，跳过。</Text> :
+                        result.code === Status.WA || result.code === Status.PE ? <>
+                          {
+                            result.code === Status.PE ? <Text>格式错误{ objective && objective.acceptPE ? '，计为通过' : '' }。</Text> : undefined
+                          }
+                          {
+                            result.data.message ? <Text>检查器：{result.data.message}</Text> : undefined
+                          }
+                          {
+                            result.data.got === undefined ? <Text>交互器判定答案错误。</Text> : <>
+                              <Text>
+                                期望：
+                              </Text>
+                              <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ result.data.expected }</code></pre>
+                              <Text>
+                                得到：
+                              </Text>
+                              {
+                                result.data.got.length ?
+                                <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ result.data.got }</code></pre>:
+                                <Text fontSize={12} color='whiteAlpha.600'>你的程序没有输出。</Text>
+                              }
+                            </>
+                          }
+                          {
+                            result.data.stderr ? <>
+                              <Text>
+                                标准错误：
+                              </Text>
+                              <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ result.data.stderr }</code></pre>
+                            </> : undefined
+                          }
+                        </> :
+                        result.code === Status.PC ? <>
+                          <Text as='span'>
+                            部分正确，得分比例 {(result.data.score*100).toFixed(1)}%
                           </Text>
                           {
-                            result.data.got.length ?
-                            <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ result.data.got }</code></pre>:
-                            <Text fontSize={12} color='whiteAlpha.600'>你的程序没有输出。</Text>
+                            result.data.message ? <Text>检查器：{result.data.message}</Text> : undefined
                           }
                         </> :
                         result.code === Status.OK ? <Text as='span'>
                           通过测试点，耗费{(result.data.execTime*1000).toFixed(1)}ms，{result.data.execMemory/1000} KB
                         </Text> :
-                        result.code === Status.RE ? <Text as='span'>
-                          运行时错误，终止信号：{result.data.termsig}
-                        </Text> :
+                        result.code === Status.RE ? <>
+                          <Text as='span'>
+                            {
+                              result.data.termsig ? 
+                              `运行时错误，终止信号：${result.data.termsig}` :
+                              `运行时错误，退出码：${result.data.exitCode}`
+                            }
+                          </Text>
+                          {
+                            result.data.stderr ? 
+                            <pre><code style={{ fontFamily: 'var(--mono-font)' }}>{ result.data.stderr }</code></pre> : undefined
+                          }
+                        </> :
                         result.code === Status.TLE ? <Text as='span'>
                           超时。
                         </Text> : 
@@ -413,6 +527,9 @@ const UnitPage: React.FC<PageProps> = props => {
                         </Text> : 
                         result.code === Status.SE ? <Text as='span'>
                           检测到恶意代码，系统调用号：{result.data.syscall}
+                        </Text> : 
+                        result.code === Status.OLE ? <Text as='span'>
+                          输出超出限制。
                         </Text> : undefined
                       }
                     </Box>